@@ -0,0 +1,70 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/mchudgins/certMgr/pkg/backend"
+	"github.com/mchudgins/certMgr/pkg/certMgr"
+	"github.com/mchudgins/certMgr/pkg/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "examines the backend's audit log",
+}
+
+// auditVerifyCmd represents the audit verify command
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify [audit log]",
+	Short: "verifies that no entry of the audit log was altered or removed",
+	Long: `Verifies the hash chain of the backend's audit log (by default
+--backend.auditFilename) and the signing CA's signature of each checkpoint.
+Exits with an error if any entry was altered, removed or reordered.
+
+Entries after the last checkpoint are reported: they are chained, but
+could have been rewritten by someone without the CA key.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := utils.NewAppConfig(cmd)
+		if err != nil {
+			log.WithField("error", err).
+				Fatal("an error occurred while obtaining the application configuration")
+		}
+
+		filename := cfg.Backend.AuditFilename
+		if len(args) > 0 {
+			filename = args[0]
+		}
+
+		if err = backend.VerifyAuditLog(cfg, filename); err != nil {
+			log.WithError(err).WithField("file", filename).Fatal("audit log verification failed")
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditVerifyCmd)
+
+	auditVerifyCmd.Flags().String("backend.auditFilename",
+		certMgr.DefaultAppConfig.Backend.AuditFilename,
+		"the backend's audit log")
+	auditVerifyCmd.Flags().String("backend.signingCACertificate",
+		certMgr.DefaultAppConfig.Backend.SigningCACertificate,
+		"the pem-encoded signing CA whose key signed the checkpoints")
+}
@@ -90,16 +90,124 @@ func init() {
 	backendCmd.PersistentFlags().String("caKey",
 		certMgr.DefaultAppConfig.Backend.SigningCAKeyFilename,
 		"CA key filename")
+	backendCmd.PersistentFlags().String("backend.caKeyPassphraseEnv",
+		certMgr.DefaultAppConfig.Backend.CAKeyPassphraseEnv,
+		"environment variable holding the CA key's passphrase")
+	backendCmd.PersistentFlags().String("backend.caKeyPassphraseFile",
+		certMgr.DefaultAppConfig.Backend.CAKeyPassphraseFile,
+		"file holding the CA key's passphrase")
+	backendCmd.PersistentFlags().String("backend.pkcs11.module",
+		certMgr.DefaultAppConfig.Backend.PKCS11.Module,
+		"PKCS#11 module of the token holding the CA key (replaces --caKey)")
+	backendCmd.PersistentFlags().String("backend.pkcs11.tokenLabel",
+		certMgr.DefaultAppConfig.Backend.PKCS11.TokenLabel,
+		"label of the PKCS#11 token holding the CA key")
+	backendCmd.PersistentFlags().String("backend.pkcs11.keyLabel",
+		certMgr.DefaultAppConfig.Backend.PKCS11.KeyLabel,
+		"label of the CA key in the PKCS#11 token")
+	backendCmd.PersistentFlags().String("backend.pkcs11.pinEnv",
+		certMgr.DefaultAppConfig.Backend.PKCS11.PinEnv,
+		"environment variable holding the PKCS#11 token's PIN")
+	backendCmd.PersistentFlags().String("backend.pkcs11.pinFile",
+		certMgr.DefaultAppConfig.Backend.PKCS11.PinFile,
+		"file holding the PKCS#11 token's PIN")
+	backendCmd.PersistentFlags().String("backend.remoteSigner.address",
+		certMgr.DefaultAppConfig.Backend.RemoteSigner.Address,
+		"address of the remote signer holding the CA key (replaces --caKey)")
+	backendCmd.PersistentFlags().String("backend.remoteSigner.serverName",
+		certMgr.DefaultAppConfig.Backend.RemoteSigner.ServerName,
+		"name expected in the remote signer's certificate")
+	backendCmd.PersistentFlags().String("backend.remoteSigner.caFilename",
+		certMgr.DefaultAppConfig.Backend.RemoteSigner.CAFilename,
+		"CA bundle verifying the remote signer's certificate")
+	backendCmd.PersistentFlags().String("backend.remoteSigner.certFilename",
+		certMgr.DefaultAppConfig.Backend.RemoteSigner.CertFilename,
+		"client certificate presented to the remote signer")
+	backendCmd.PersistentFlags().String("backend.remoteSigner.keyFilename",
+		certMgr.DefaultAppConfig.Backend.RemoteSigner.KeyFilename,
+		"client key presented to the remote signer")
+	backendCmd.PersistentFlags().Int("backend.remoteSigner.timeout",
+		certMgr.DefaultAppConfig.Backend.RemoteSigner.Timeout,
+		"# of seconds to wait for the remote signer")
 	backendCmd.PersistentFlags().Int("backend.maxDuration",
-		10,
+		certMgr.DefaultAppConfig.Backend.MaxDuration,
 		"maximum certificate lifetime (in # of days)")
+	backendCmd.PersistentFlags().Bool("backend.clampDuration",
+		certMgr.DefaultAppConfig.Backend.ClampDuration,
+		"shorten requests beyond the maximum lifetime, rather than refusing them")
 	backendCmd.PersistentFlags().StringSlice("backend.authorizedCreators",
 		certMgr.DefaultAppConfig.Backend.AuthorizedCreators,
-		"email addresses/user ID's of those who may create certificates")
+		"email addresses/user ID's (or group:name) of those who may create certificates; * wildcards are permitted")
+	backendCmd.PersistentFlags().String("backend.policyFilename",
+		certMgr.DefaultAppConfig.Backend.PolicyFilename,
+		"YAML or JSON file of the names & profiles each user or group may request")
+	backendCmd.PersistentFlags().Bool("backend.apexWildcards",
+		certMgr.DefaultAppConfig.Backend.ApexWildcards,
+		"permit wildcards directly under the CA's permitted domains (e.g. *.dstcorp.io)")
+	backendCmd.PersistentFlags().StringSlice("backend.allowedKeyTypes",
+		certMgr.DefaultAppConfig.Backend.AllowedKeyTypes,
+		"key types this CA will generate (ecdsa-p256, ecdsa-p384, rsa-2048, rsa-3072, rsa-4096, ed25519)")
+	backendCmd.PersistentFlags().StringSlice("backend.allowedProfiles",
+		certMgr.DefaultAppConfig.Backend.AllowedProfiles,
+		"certificate profiles requesters may select (an empty list permits server, client, mtls & email)")
+	backendCmd.PersistentFlags().String("backend.defaultProfile",
+		certMgr.DefaultAppConfig.Backend.DefaultProfile,
+		"certificate profile used when a request does not specify one")
+
+	backendCmd.PersistentFlags().String("backend.auditFilename",
+		certMgr.DefaultAppConfig.Backend.AuditFilename,
+		"tamper-evident record of every CA operation (empty disables it)")
+	backendCmd.PersistentFlags().Int("backend.auditInterval",
+		certMgr.DefaultAppConfig.Backend.AuditInterval,
+		"# of audit log entries between checkpoints signed by the CA")
+
+	backendCmd.PersistentFlags().String("backend.ctLogFilename",
+		certMgr.DefaultAppConfig.Backend.CTLogFilename,
+		"transparency log of every issued certificate (empty disables it)")
+	backendCmd.PersistentFlags().Int("backend.ctPublishInterval",
+		certMgr.DefaultAppConfig.Backend.CTPublishInterval,
+		"# of minutes between signed tree heads of the transparency log (0 publishes only at startup)")
+
+	backendCmd.PersistentFlags().String("backend.storeType",
+		certMgr.DefaultAppConfig.Backend.StoreType,
+		"certificate inventory store (bolt or memory)")
+	backendCmd.PersistentFlags().String("backend.storeFilename",
+		certMgr.DefaultAppConfig.Backend.StoreFilename,
+		"certificate inventory filename (bolt store only)")
+
+	backendCmd.PersistentFlags().String("backend.crlNumberFilename",
+		certMgr.DefaultAppConfig.Backend.CRLNumberFilename,
+		"file holding the number of the next CRL")
+	backendCmd.PersistentFlags().Int("backend.crlRefreshInterval",
+		certMgr.DefaultAppConfig.Backend.CRLRefreshInterval,
+		"# of hours between scheduled CRL generation (0 regenerates only on revocation)")
+	backendCmd.PersistentFlags().Int("backend.crlValidity",
+		certMgr.DefaultAppConfig.Backend.CRLValidity,
+		"# of hours until a CRL's nextUpdate")
+
+	backendCmd.PersistentFlags().String("backend.ocspListenAddress",
+		certMgr.DefaultAppConfig.Backend.OCSPListenAddress,
+		"listen address of the OCSP responder (empty disables it)")
+	backendCmd.PersistentFlags().Int("backend.ocspValidity",
+		certMgr.DefaultAppConfig.Backend.OCSPValidity,
+		"# of hours until an OCSP response's nextUpdate")
+
+	backendCmd.PersistentFlags().String("backend.issuerURL",
+		certMgr.DefaultAppConfig.Backend.IssuerURL,
+		"URL of the signing CA's certificate, placed in issued certificates")
+	backendCmd.PersistentFlags().String("backend.ocspURL",
+		certMgr.DefaultAppConfig.Backend.OCSPURL,
+		"URL of the OCSP responder, placed in issued certificates")
+	backendCmd.PersistentFlags().StringSlice("backend.crlURLs",
+		certMgr.DefaultAppConfig.Backend.CRLURLs,
+		"CRL distribution points placed in issued certificates")
 
 	backendCmd.PersistentFlags().String("backend.bundle",
 		certMgr.DefaultAppConfig.Backend.Bundle,
 		"CA key filename")
+	backendCmd.PersistentFlags().String("backend.rootCACertificate",
+		certMgr.DefaultAppConfig.Backend.RootCACertificate,
+		"the pem-encoded root CA, completing a CA bundle which does not include it")
 	backendCmd.PersistentFlags().String("backend.signingCACertificate",
 		certMgr.DefaultAppConfig.Backend.SigningCACertificate,
 		"CA key filename")
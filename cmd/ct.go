@@ -0,0 +1,66 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/mchudgins/certMgr/pkg/backend"
+	"github.com/mchudgins/certMgr/pkg/certMgr"
+	"github.com/mchudgins/certMgr/pkg/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// ctCmd represents the ct command
+var ctCmd = &cobra.Command{
+	Use:   "ct",
+	Short: "examines the backend's certificate transparency log",
+}
+
+// ctVerifyCmd represents the ct verify command
+var ctVerifyCmd = &cobra.Command{
+	Use:   "verify <certificate>",
+	Short: "verifies that a certificate is included in the transparency log",
+	Long: `Fetches the transparency log's latest signed tree head, checks that it is
+signed by the signing CA, then verifies the inclusion proof of the PEM
+encoded certificate against it. Exits with an error if the certificate is
+not included.
+
+A certificate is only included once a tree head covering it is published
+(every --backend.ctPublishInterval minutes).`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := utils.NewAppConfig(cmd)
+		if err != nil {
+			log.WithField("error", err).
+				Fatal("an error occurred while obtaining the application configuration")
+		}
+
+		if err = backend.VerifyCTInclusion(cfg, viper.GetString("url"), args[0]); err != nil {
+			log.WithError(err).WithField("certificate", args[0]).Fatal("transparency log verification failed")
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(ctCmd)
+	ctCmd.AddCommand(ctVerifyCmd)
+
+	ctVerifyCmd.Flags().String("url", "http://localhost:8443/ct/v1/",
+		"URL of the backend's transparency log")
+	ctVerifyCmd.Flags().String("backend.signingCACertificate",
+		certMgr.DefaultAppConfig.Backend.SigningCACertificate,
+		"the pem-encoded signing CA whose key signs the tree heads")
+}
@@ -22,29 +22,37 @@ package cmd
 
 import (
 	"context"
+	"crypto/x509/pkix"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
-	"io/ioutil"
-
 	log "github.com/sirupsen/logrus"
 	"github.com/mchudgins/certMgr/pkg/backend"
 	"github.com/mchudgins/certMgr/pkg/utils"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/term"
 )
 
 type newCmdConfig struct {
 	Config       string `json:"config"`
 	CertFilename string `json:"certFilename"`
 	KeyFilename  string `json:"keyFilename"`
+	CSRFilename  string `json:"csrFilename"`
+	Format       string `json:"format"`
+	OutFilename  string `json:"outFilename"`
+	PasswordFile string `json:"passwordFile"`
+	KeyType      string `json:"keyType"`
+	Profile      string `json:"profile"`
 	Duration     int    `json:"duration"`
 	Verbose      bool   `json:"verbose"`
 
 	SigningCertFilename   string `json:"signingCertFilename"`
 	SigningKeyFilename    string `json:"signingKeyFilenae"`
 	SigningBundleFilename string `json:"signingBundleFilename"`
+	SigningKeyPassphrase  string `json:"signingKeyPassphraseFile"`
 }
 
 // defaultConfig holds default values
@@ -52,7 +60,9 @@ var defaultConfig = &newCmdConfig{
 	Config:       "",
 	CertFilename: "cert.pem",
 	KeyFilename:  "key.pem",
+	Format:       backend.DefaultFormat,
 	Duration:     90,
+	KeyType:      backend.DefaultKeyType,
 
 	SigningCertFilename:   "ca/ucap/ucap-ca.crt",
 	SigningKeyFilename:    "ca/ucap/private/ucap-ca.key",
@@ -63,9 +73,17 @@ var defaultConfig = &newCmdConfig{
 var newCmd = &cobra.Command{
 	Use:   "new <common name>",
 	Short: "Create a new certificate",
-	Long:  `Creates a new certificate and key for the specified common name.`,
+	Long: `Creates a new certificate and key for the specified common name.
+
+When --csr is provided, the certificate signing request is signed instead
+and no key is generated; the names are taken from the CSR.
+
+--format selects the output: pem (the certificate & chain in --cert, the key in
+--key), der, chain, combined, pkcs12, jks or k8s-secret. The pkcs12 and jks
+formats are protected by the password in --password-file, $` + keystorePasswordEnv + `
+or, failing those, entered at the terminal.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) == 0 {
+		if len(args) == 0 && len(viper.GetString("csr")) == 0 {
 			fmt.Fprint(cmd.OutOrStderr(), "fatal: a common name for the certificate must be provided on the command line\n")
 			cmd.Usage()
 			os.Exit(1)
@@ -81,56 +99,161 @@ var newCmd = &cobra.Command{
 		// flags need special handling (sigh)
 		cfg.CertFilename = viper.GetString("cert")
 		cfg.KeyFilename = viper.GetString("key")
+		cfg.CSRFilename = viper.GetString("csr")
+		cfg.Format = viper.GetString("format")
+		cfg.OutFilename = viper.GetString("out")
+		cfg.PasswordFile = viper.GetString("password-file")
+		cfg.KeyType = viper.GetString("key-type")
+		cfg.Profile = viper.GetString("profile")
 		cfg.Duration = viper.GetInt("duration")
 		cfg.Verbose = viper.GetBool("verbose")
 
 		cfg.SigningCertFilename = viper.GetString("signerCert")
 		cfg.SigningKeyFilename = viper.GetString("signerKey")
 		cfg.SigningBundleFilename = viper.GetString("signerBundle")
+		cfg.SigningKeyPassphrase = viper.GetString("signerKeyPassphraseFile")
 
 		if cfg.Verbose {
 			log.SetLevel(log.DebugLevel)
 		}
 		log.Debugf("Current config:  %+v", cfg)
 
+		format := strings.ToLower(cfg.Format)
+		if _, ok := formatFilenames[format]; !ok && format != backend.FormatPEM {
+			log.WithField("format", cfg.Format).Fatal("unsupported format (expected one of " +
+				strings.Join(backend.SupportedFormats, ", ") + ")")
+		}
+		if len(cfg.CSRFilename) > 0 && backend.FormatNeedsKey(format) {
+			log.WithField("format", format).Fatal("the key of a CSR is not available for this format")
+		}
+		if len(cfg.OutFilename) == 0 {
+			cfg.OutFilename = formatFilenames[format]
+		}
+
+		var password string
+		if backend.FormatNeedsPassword(format) {
+			var passwordFile backend.PassphraseFunc
+			if len(cfg.PasswordFile) > 0 {
+				passwordFile = backend.FilePassphrase(cfg.PasswordFile)
+			}
+			pw, err := backend.FirstPassphrase(passwordFile,
+				backend.EnvPassphrase(keystorePasswordEnv),
+				promptPassphrase(cfg.OutFilename))()
+			if err != nil {
+				log.WithError(err).WithField("file", cfg.OutFilename).Fatal("a password is required for the keystore")
+			}
+			password = string(pw)
+		}
+
+		// an encrypted signer key's passphrase comes from the file, the environment or, failing those, the terminal
+		var passphraseFile backend.PassphraseFunc
+		if len(cfg.SigningKeyPassphrase) > 0 {
+			passphraseFile = backend.FilePassphrase(cfg.SigningKeyPassphrase)
+		}
+		passphrase := backend.FirstPassphrase(passphraseFile,
+			backend.EnvPassphrase(backend.DefaultPassphraseEnv),
+			promptPassphrase(cfg.SigningKeyFilename))
+
 		// initialize the SimpleCA
 		ca, err := backend.NewCertificateAuthority("signingCert",
 			cfg.SigningCertFilename,
 			cfg.SigningKeyFilename,
-			cfg.SigningBundleFilename)
+			cfg.SigningBundleFilename,
+			passphrase)
 		if err != nil {
 			log.WithError(err).Fatal("unable to initialize the CA")
 		}
 
 		ctx := context.Background()
 
-		cert, key, err := ca.CreateCertificate(ctx, args[0], args, time.Duration(cfg.Duration)*time.Hour*24)
-		if err != nil {
-			log.WithField("error", err).WithField("Subject Name", args[0]).Fatal("unable to create certificate")
-		}
+		var cert, key string
+		if len(cfg.CSRFilename) > 0 {
+			csr, err := utils.FindAndReadFile(cfg.CSRFilename, "certificate signing request")
+			if err != nil {
+				log.WithError(err).WithField("file", cfg.CSRFilename).Fatal("unable to read the CSR")
+			}
 
-		certFile, err := os.OpenFile(cfg.CertFilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
-		if err != nil {
-			log.WithError(err).WithField("file", cfg.CertFilename).Fatal("unable to open file")
-			os.Exit(1)
+			cert, err = ca.SignCertificateRequest(ctx, csr, time.Duration(cfg.Duration)*time.Hour*24, cfg.Profile, nil)
+			if err != nil {
+				log.WithField("error", err).WithField("CSR", cfg.CSRFilename).Fatal("unable to sign certificate request")
+			}
+		} else {
+			cert, key, err = ca.CreateCertificate(ctx, args[0], args, time.Duration(cfg.Duration)*time.Hour*24,
+				cfg.KeyType, cfg.Profile, pkix.Name{}, nil)
+			if err != nil {
+				log.WithField("error", err).WithField("Subject Name", args[0]).Fatal("unable to create certificate")
+			}
 		}
-		defer certFile.Close()
-		certFile.WriteString(cert)
 
-		bundle, err := ioutil.ReadFile(cfg.SigningBundleFilename)
-		certFile.Write(bundle)
+		// the CA has verified & ordered its bundle
+		bundle := ca.Bundle
 
-		keyFile, err := os.OpenFile(cfg.KeyFilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0400)
-		if err != nil {
-			log.WithError(err).WithField("file", cfg.KeyFilename).Fatal("unable to open file")
-			os.Exit(1)
+		if format == backend.FormatPEM {
+			writeFile(cfg.CertFilename, []byte(cert+bundle), 0666)
+		} else {
+			// the common name names the keystore entry & the Secret (a CSR's is taken from the certificate)
+			var name string
+			if len(cfg.CSRFilename) == 0 {
+				name = args[0]
+			}
+			content, err := backend.EncodeCredentials(format, cert, key, bundle, password, name)
+			if err != nil {
+				log.WithError(err).WithField("format", format).Fatal("unable to encode the certificate")
+			}
+			writeFile(cfg.OutFilename, content, 0600)
+		}
+
+		// the client holds the key for a CSR, and some formats hold the key themselves
+		if len(key) == 0 || backend.FormatNeedsKey(format) {
+			return
 		}
-		defer keyFile.Close()
-		keyFile.WriteString(key)
 
+		writeFile(cfg.KeyFilename, []byte(key), 0400)
 	},
 }
 
+// keystorePasswordEnv holds the password of pkcs12 & jks output
+const keystorePasswordEnv = "CERTMGR_KEYSTORE_PASSWORD"
+
+// formatFilenames are the default output files of each format
+var formatFilenames = map[string]string{
+	backend.FormatDER:      "cert.der",
+	backend.FormatChain:    "chain.pem",
+	backend.FormatCombined: "combined.pem",
+	backend.FormatPKCS12:   "cert.p12",
+	backend.FormatJKS:      "keystore.jks",
+	backend.FormatK8s:      "secret.yaml",
+}
+
+// writeFile creates (or truncates) the file & writes the data, exiting on failure
+func writeFile(filename string, data []byte, perm os.FileMode) {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		log.WithError(err).WithField("file", filename).Fatal("unable to open file")
+	}
+	defer f.Close()
+
+	if _, err = f.Write(data); err != nil {
+		log.WithError(err).WithField("file", filename).Fatal("unable to write file")
+	}
+}
+
+// promptPassphrase asks for the key's passphrase on the terminal
+func promptPassphrase(keyFilename string) backend.PassphraseFunc {
+	return func() ([]byte, error) {
+		fd := int(os.Stdin.Fd())
+		if !term.IsTerminal(fd) {
+			return nil, backend.ErrPassphraseRequired
+		}
+
+		fmt.Fprintf(os.Stderr, "Enter the passphrase for %s: ", keyFilename)
+		passphrase, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+
+		return passphrase, err
+	}
+}
+
 func init() {
 	RootCmd.AddCommand(newCmd)
 
@@ -144,9 +267,20 @@ func init() {
 	// is called directly, e.g.:
 	// newCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	newCmd.Flags().String("cert", defaultConfig.CertFilename, "output file for the PEM encoded certificate")
+	newCmd.Flags().String("csr", defaultConfig.CSRFilename, "sign the PEM encoded certificate signing request in this file")
+	newCmd.Flags().String("format", defaultConfig.Format,
+		"output format: "+strings.Join(backend.SupportedFormats, ", "))
+	newCmd.Flags().String("out", defaultConfig.OutFilename, "output file for formats other than pem (default: by format, e.g. cert.p12)")
+	newCmd.Flags().String("password-file", defaultConfig.PasswordFile,
+		"file holding the pkcs12 or jks password (default: $"+keystorePasswordEnv+" or prompt)")
 	newCmd.Flags().Int("duration", defaultConfig.Duration, "# of days duration for the certificate's validity")
 	newCmd.Flags().String("key", defaultConfig.KeyFilename, "output file for the PEM encoded key")
+	newCmd.Flags().String("key-type", defaultConfig.KeyType,
+		"type of key to generate: "+strings.Join(backend.SupportedKeyTypes, ", "))
+	newCmd.Flags().String("profile", defaultConfig.Profile, "certificate profile (server, client, mtls, email, or code-signing where permitted)")
 	newCmd.Flags().String("signerCert", defaultConfig.SigningCertFilename, "signer CA certificate file")
 	newCmd.Flags().String("signerKey", defaultConfig.SigningKeyFilename, "signer CA key file")
 	newCmd.Flags().String("signerBundle", defaultConfig.SigningBundleFilename, "signer CA bundle file")
+	newCmd.Flags().String("signerKeyPassphraseFile", defaultConfig.SigningKeyPassphrase,
+		"file holding the signer CA key's passphrase (default: $"+backend.DefaultPassphraseEnv+" or prompt)")
 }
@@ -0,0 +1,95 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/mchudgins/certMgr/pkg/backend"
+	"github.com/mchudgins/certMgr/pkg/certMgr"
+	"github.com/mchudgins/certMgr/pkg/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// signerCmd represents the signer command
+var signerCmd = &cobra.Command{
+	Use:   "signer",
+	Short: "holds the CA key, signing on behalf of the backend (listening on port :50052)",
+	Long: `The signer holds the CA's private key in a separate, hardened process.
+The backend is configured with --backend.remoteSigner.address to ask the
+signer for each signature, rather than loading the CA key itself.
+
+Backends must present a client certificate issued by the
+--signer.clientCAFilename bundle, and every signature is recorded in the
+--signer.auditFilename audit trail.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := utils.NewAppConfig(cmd)
+		if err != nil {
+			log.WithField("error", err).
+				Fatal("an error occurred while obtaining the application configuration")
+		}
+
+		// the flag name doesn't match the field name
+		cfg.Backend.SigningCAKeyFilename = viper.GetString("caKey")
+
+		utils.StartUpMessage(*cfg)
+
+		backend.RunSigner(cfg)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(signerCmd)
+
+	signerCmd.PersistentFlags().String("keyFilename", certMgr.DefaultAppConfig.KeyFilename,
+		"filename of the pem-encoded key for the service's certificate")
+	signerCmd.PersistentFlags().String("caKey",
+		certMgr.DefaultAppConfig.Backend.SigningCAKeyFilename,
+		"CA key filename")
+	signerCmd.PersistentFlags().String("backend.caKeyPassphraseEnv",
+		certMgr.DefaultAppConfig.Backend.CAKeyPassphraseEnv,
+		"environment variable holding the CA key's passphrase")
+	signerCmd.PersistentFlags().String("backend.caKeyPassphraseFile",
+		certMgr.DefaultAppConfig.Backend.CAKeyPassphraseFile,
+		"file holding the CA key's passphrase")
+	signerCmd.PersistentFlags().String("backend.pkcs11.module",
+		certMgr.DefaultAppConfig.Backend.PKCS11.Module,
+		"PKCS#11 module of the token holding the CA key (replaces --caKey)")
+	signerCmd.PersistentFlags().String("backend.pkcs11.tokenLabel",
+		certMgr.DefaultAppConfig.Backend.PKCS11.TokenLabel,
+		"label of the PKCS#11 token holding the CA key")
+	signerCmd.PersistentFlags().String("backend.pkcs11.keyLabel",
+		certMgr.DefaultAppConfig.Backend.PKCS11.KeyLabel,
+		"label of the CA key in the PKCS#11 token")
+	signerCmd.PersistentFlags().String("backend.pkcs11.pinEnv",
+		certMgr.DefaultAppConfig.Backend.PKCS11.PinEnv,
+		"environment variable holding the PKCS#11 token's PIN")
+	signerCmd.PersistentFlags().String("backend.pkcs11.pinFile",
+		certMgr.DefaultAppConfig.Backend.PKCS11.PinFile,
+		"file holding the PKCS#11 token's PIN")
+
+	signerCmd.PersistentFlags().String("signer.listenAddress",
+		certMgr.DefaultAppConfig.Signer.ListenAddress,
+		"listen address for the signer's gRPC service")
+	signerCmd.PersistentFlags().String("signer.clientCAFilename",
+		certMgr.DefaultAppConfig.Signer.ClientCAFilename,
+		"CA bundle verifying the backends' client certificates")
+	signerCmd.PersistentFlags().StringSlice("signer.authorizedClients",
+		certMgr.DefaultAppConfig.Signer.AuthorizedClients,
+		"names in the client certificates permitted to sign (wildcards permitted; default any verified client)")
+	signerCmd.PersistentFlags().String("signer.auditFilename",
+		certMgr.DefaultAppConfig.Signer.AuditFilename,
+		"append-only record of every signature")
+}
@@ -236,5 +236,6 @@ echo current directory: `pwd`
 echo Creating CA bundle....
 cat $tla/$tla-ca.crt > $tla/ca-bundle.pem
 cat intermediate-ca/intermediate-ca.crt >> $tla/ca-bundle.pem
+cat root-ca/root-ca.crt >> $tla/ca-bundle.pem
 
 echo Done.
@@ -0,0 +1,508 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mchudgins/certMgr/pkg/certMgr"
+	pb "github.com/mchudgins/certMgr/pkg/service"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// audit log events
+const (
+	auditCALoad  = "ca.load"
+	auditClose   = "audit.close"
+	auditCreate  = "certificate.create"
+	auditSignCSR = "certificate.sign"
+	auditRenew   = "certificate.renew"
+	auditRevoke  = "certificate.revoke"
+)
+
+// audit log results
+const (
+	auditOK     = "ok"
+	auditDenied = "denied" // the CA refused the request
+	auditFailed = "failed" // the CA was unable to complete the request
+)
+
+// auditedMethods are the CertMgr methods recorded in the audit log, by their event
+var auditedMethods = map[string]string{
+	certMgrServicePrefix + "CreateCertificate":      auditCreate,
+	certMgrServicePrefix + "SignCertificateRequest": auditSignCSR,
+	certMgrServicePrefix + "RenewCertificate":       auditRenew,
+	certMgrServicePrefix + "RevokeCertificate":      auditRevoke,
+}
+
+// auditLog is a tamper-evident, append-only record of every CA operation. Each
+// entry carries the hash of the entry before it, so altering or removing an
+// entry breaks the chain, and every interval'th entry is a checkpoint signed by
+// the CA key, so the chain cannot be rewritten without the key.
+type auditLog struct {
+	sync.Mutex
+	file     *os.File
+	ca       string
+	signer   crypto.Signer
+	interval int    // # of entries between checkpoints
+	seq      uint64 // of the last entry
+	prev     string // hash of the last entry
+	unsigned int    // # of entries since the last checkpoint
+}
+
+type auditEntry struct {
+	Seq           uint64    `json:"seq"`
+	Time          time.Time `json:"time"`
+	Event         string    `json:"event"`
+	CA            string    `json:"ca,omitempty"`
+	Requester     string    `json:"requester,omitempty"`
+	CorrelationID string    `json:"correlationId,omitempty"`
+	RequestHash   string    `json:"requestHash,omitempty"`
+	Serial        string    `json:"serial,omitempty"`
+	Result        string    `json:"result"`
+	Error         string    `json:"error,omitempty"`
+	Interval      int       `json:"interval,omitempty"` // # of entries between the checkpoints that follow a CA load
+	Prev          string    `json:"prev"`
+	Hash          string    `json:"hash"`
+	Signature     string    `json:"signature,omitempty"` // the CA's signature of Hash, on checkpoints
+}
+
+// digest returns the hash of the entry, which covers every field but the hash & signature
+func (e auditEntry) digest() string {
+	e.Hash, e.Signature = "", ""
+	b, _ := json.Marshal(e)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// openAuditLog opens the audit log, continuing the chain of any existing entries.
+// An empty filename disables the audit log.
+func openAuditLog(filename string, interval int) (*auditLog, error) {
+	if len(filename) == 0 {
+		log.Warn("CA operations will only be recorded in the log")
+		return nil, nil
+	}
+	if interval < 1 {
+		interval = 1
+	}
+
+	a := &auditLog{interval: interval}
+
+	// find the end of the chain
+	if f, err := os.Open(filename); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1024*1024)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+
+			var e auditEntry
+			if err = json.Unmarshal(line, &e); err != nil || e.Hash != e.digest() {
+				f.Close()
+				return nil, fmt.Errorf("entry %d of the audit log %s is damaged", a.seq+1, filename)
+			}
+			a.seq, a.prev = e.Seq, e.Hash
+			if len(e.Signature) > 0 {
+				a.unsigned = 0
+			} else {
+				a.unsigned++
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	a.file = f
+
+	log.WithFields(log.Fields{"file": filename, "entries": a.seq}).Info("audit log opened")
+
+	return a, nil
+}
+
+// setSigner selects the CA whose key signs the checkpoints
+func (a *auditLog) setSigner(caName string, key crypto.Signer) {
+	if a == nil {
+		return
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	a.ca, a.signer = caName, key
+}
+
+// record appends the outcome of the request to the audit log
+func (a *auditLog) record(ctx context.Context, event string, req interface{}, serial string, err error) error {
+	if a == nil {
+		return nil
+	}
+
+	e := auditEntry{
+		Event:         event,
+		CA:            auditedCA(ctx),
+		Requester:     requester(ctx),
+		CorrelationID: correlationID(ctx),
+		RequestHash:   hashRequest(req),
+		Serial:        serial,
+		Result:        auditResult(err),
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	return a.append(e, false)
+}
+
+// recordCALoad appends the loading of the CA & its configuration to the audit log
+// as a checkpoint
+func (a *auditLog) recordCALoad(c *ca, cfg interface{}, err error) error {
+	if a == nil {
+		return nil
+	}
+
+	e := auditEntry{Event: auditCALoad, RequestHash: hashRequest(cfg), Result: auditResult(err)}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if c != nil {
+		e.CA = c.Name
+	}
+	if c != nil && c.SigningCertificate.SerialNumber != nil {
+		e.Serial = SerialString(c.SigningCertificate.SerialNumber)
+	}
+
+	return a.append(e, true)
+}
+
+// append chains the entry to the last entry & writes it, signing it if it is a checkpoint
+func (a *auditLog) append(e auditEntry, checkpoint bool) error {
+	a.Lock()
+	defer a.Unlock()
+
+	e.Seq = a.seq + 1
+	e.Time = time.Now().UTC()
+	if len(e.CA) == 0 {
+		e.CA = a.ca
+	}
+	e.Prev = a.prev
+	if e.Event == auditCALoad && a.signer != nil {
+		// lets the verifier detect the removal of checkpoints' signatures
+		e.Interval = a.interval
+	}
+	e.Hash = e.digest()
+
+	if a.signer != nil && (checkpoint || a.unsigned+1 >= a.interval) {
+		signature, err := signAuditHash(a.signer, e.Hash)
+		if err != nil {
+			// the entry is recorded regardless; the next entry will be a checkpoint
+			log.WithError(err).WithField("seq", e.Seq).Error("Unable to sign the audit log checkpoint")
+		} else {
+			e.Signature = signature
+		}
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.file.Write(append(line, '\n'))
+	if err == nil {
+		err = a.file.Sync()
+	}
+	if err != nil {
+		log.WithError(err).WithField("file", a.file.Name()).Error("Unable to write to the audit log")
+		return err
+	}
+
+	a.seq, a.prev = e.Seq, e.Hash
+	if len(e.Signature) > 0 {
+		a.unsigned = 0
+	} else {
+		a.unsigned++
+	}
+
+	return nil
+}
+
+// Close ends the audit log with a checkpoint
+func (a *auditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+
+	if err := a.append(auditEntry{Event: auditClose, Result: auditOK}, true); err != nil {
+		a.file.Close()
+		return err
+	}
+	return a.file.Close()
+}
+
+// UnaryServerInterceptor records the outcome of each audited call, including
+// those refused by the authorizer. A certificate whose issuance cannot be
+// recorded is never released.
+func (a *auditLog) UnaryServerInterceptor(ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	event, ok := auditedMethods[info.FullMethod]
+	if !ok || a == nil {
+		return handler(ctx, req)
+	}
+
+	ctx = withAuditedCA(ctx)
+	resp, err := handler(ctx, req)
+
+	if auditErr := a.record(ctx, event, req, auditSerial(req, resp, err), err); auditErr != nil && err == nil {
+		return nil, status.Error(codes.Unavailable, "Unable to record the operation in the audit log")
+	}
+
+	return resp, err
+}
+
+// auditedCAKey holds, in the context of an audited call, the name of the CA which handled it
+type auditedCAKey struct{}
+
+// withAuditedCA prepares the context for the handler to note the CA handling the call
+func withAuditedCA(ctx context.Context) context.Context {
+	return context.WithValue(ctx, auditedCAKey{}, new(string))
+}
+
+// noteAuditedCA records the CA handling an audited call
+func noteAuditedCA(ctx context.Context, name string) {
+	if p, ok := ctx.Value(auditedCAKey{}).(*string); ok {
+		*p = name
+	}
+}
+
+// auditedCA returns the CA which handled the audited call, if known
+func auditedCA(ctx context.Context) string {
+	if p, ok := ctx.Value(auditedCAKey{}).(*string); ok {
+		return *p
+	}
+	return ""
+}
+
+// auditSerial returns the serial number of the certificate issued, renewed or revoked
+func auditSerial(req interface{}, resp interface{}, err error) string {
+	if err == nil {
+		if r, ok := resp.(interface{ GetSerial() string }); ok && len(r.GetSerial()) > 0 {
+			return r.GetSerial()
+		}
+		if r, ok := resp.(interface{ GetCertificate() string }); ok {
+			if block, _ := pem.Decode([]byte(r.GetCertificate())); block != nil {
+				if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+					return SerialString(cert.SerialNumber)
+				}
+			}
+		}
+	}
+
+	if r, ok := req.(interface{ GetSerial() string }); ok {
+		return r.GetSerial()
+	}
+	return ""
+}
+
+// auditResult classifies the outcome of a request
+func auditResult(err error) string {
+	if err == nil {
+		return auditOK
+	}
+
+	var pe *policyError
+	if errors.As(err, &pe) {
+		return auditDenied
+	}
+
+	switch status.Code(err) {
+	case codes.Unknown, codes.Internal, codes.Unavailable, codes.DeadlineExceeded, codes.Canceled:
+		return auditFailed
+	default:
+		return auditDenied
+	}
+}
+
+// hashRequest returns the SHA-256 hash of the request's JSON encoding. A keystore
+// password is left out, as an unsalted hash would let it be guessed offline.
+func hashRequest(req interface{}) string {
+	if req == nil {
+		return ""
+	}
+	if r, ok := req.(*pb.CreateRequest); ok && len(r.Password) > 0 {
+		redacted := *r
+		redacted.Password = ""
+		req = &redacted
+	}
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// signAuditHash signs the (hex) hash of an entry with the CA key
+func signAuditHash(key crypto.Signer, hash string) (string, error) {
+	data, err := hex.DecodeString(hash)
+	if err != nil {
+		return "", err
+	}
+
+	signature, err := signData(key, data)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// checkAuditSignature verifies the CA's signature of the (hex) hash of an entry
+func checkAuditSignature(cert *x509.Certificate, hash string, signature string) error {
+	data, err := hex.DecodeString(hash)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return err
+	}
+
+	return checkDataSignature(cert, data, sig)
+}
+
+// auditVerification summarizes a verified audit log
+type auditVerification struct {
+	Entries     uint64 // # of entries
+	Checkpoints int    // # of entries signed by the CA
+	Unsigned    int    // # of entries after the last checkpoint
+}
+
+// verifyAuditLog checks that every entry follows the one before it, is unaltered,
+// that every checkpoint is signed by the CA certificate & that no checkpoint is
+// missing. Entries after the last checkpoint are chained, but could have been
+// rewritten by someone without the CA key.
+func verifyAuditLog(r io.Reader, cert *x509.Certificate) (auditVerification, error) {
+	var v auditVerification
+	var prev string
+	var interval int
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		var e auditEntry
+		if err := json.Unmarshal(raw, &e); err != nil {
+			return v, fmt.Errorf("line %d: not an audit log entry: %s", line, err)
+		}
+
+		// the entry must be exactly as written
+		if canonical, _ := json.Marshal(e); !bytes.Equal(canonical, raw) {
+			return v, fmt.Errorf("line %d: entry %d has been altered", line, e.Seq)
+		}
+		if e.Seq != v.Entries+1 {
+			return v, fmt.Errorf("line %d: expected entry %d, found entry %d (entries were removed or reordered)",
+				line, v.Entries+1, e.Seq)
+		}
+		if e.Prev != prev {
+			return v, fmt.Errorf("line %d: entry %d does not follow entry %d", line, e.Seq, v.Entries)
+		}
+		if e.Hash != e.digest() {
+			return v, fmt.Errorf("line %d: entry %d has been altered", line, e.Seq)
+		}
+
+		if len(e.Signature) > 0 {
+			if err := checkAuditSignature(cert, e.Hash, e.Signature); err != nil {
+				return v, fmt.Errorf("line %d: entry %d is not signed by %s: %s",
+					line, e.Seq, cert.Subject.CommonName, err)
+			}
+			v.Checkpoints++
+			v.Unsigned = 0
+
+			// only a signed CA load may change the checkpoint interval
+			if e.Event == auditCALoad {
+				interval = e.Interval
+			}
+		} else if e.Event == auditCALoad && e.Result == auditOK && v.Checkpoints > 0 {
+			// a loaded CA signs its entry; only one which failed to load has no key to sign with
+			return v, fmt.Errorf("line %d: entry %d records a CA load not signed by %s",
+				line, e.Seq, cert.Subject.CommonName)
+		} else if interval > 0 && v.Unsigned+1 >= interval {
+			// the signature was removed, or the CA was unable to sign (which it logs)
+			return v, fmt.Errorf("line %d: entry %d is not a checkpoint signed by %s",
+				line, e.Seq, cert.Subject.CommonName)
+		} else {
+			v.Unsigned++
+		}
+
+		v.Entries, prev = e.Seq, e.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return v, err
+	}
+
+	if v.Entries > 0 && v.Checkpoints == 0 {
+		return v, fmt.Errorf("none of the %d entries is signed by %s", v.Entries, cert.Subject.CommonName)
+	}
+
+	return v, nil
+}
+
+// VerifyAuditLog verifies the audit log against the signing CA's certificate
+func VerifyAuditLog(cfg *certMgr.AppConfig, filename string) error {
+	caCert, err := signingCACertificate(cfg)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	v, err := verifyAuditLog(f, caCert)
+	if err != nil {
+		return err
+	}
+
+	logger := log.WithFields(log.Fields{"file": filename, "entries": v.Entries, "checkpoints": v.Checkpoints})
+	if v.Unsigned > 0 {
+		logger.Warnf("%d entries follow the last checkpoint", v.Unsigned)
+	}
+	logger.Info("audit log verified")
+
+	return nil
+}
@@ -0,0 +1,242 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/mchudgins/certMgr/pkg/service"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// writeTestAuditLog records a CA load, a certificate issued through the interceptor,
+// a policy denial & a revocation, with a checkpoint every 2 entries
+func writeTestAuditLog(t *testing.T, c *ca, filename string) {
+	a, err := openAuditLog(filename, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.setSigner(c.Name, c.SigningKey)
+
+	if err = a.recordCALoad(c, map[string]string{"maxDuration": "365"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := metadata.NewContext(context.Background(), metadata.Pairs(
+		remoteUserMetadataKey, "alice@dstcorp.com", correlationIDMetadataKey, "b5vrd2bqsa1g00aq3ba0"))
+	info := &grpc.UnaryServerInfo{FullMethod: certMgrServicePrefix + "CreateCertificate"}
+	issue := func(ctx context.Context, req interface{}) (interface{}, error) {
+		cert, _, err := c.CreateCertificate(ctx, req.(*pb.CreateRequest).Name, nil, 24*time.Hour, "", "", pkix.Name{}, nil)
+		if err != nil {
+			return nil, statusError(err)
+		}
+		return &pb.CreateReply{Certificate: cert}, nil
+	}
+
+	if _, err = a.UnaryServerInterceptor(ctx, &pb.CreateRequest{Name: "web.dstcorp.io"}, info, issue); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.UnaryServerInterceptor(ctx, &pb.CreateRequest{Name: "web.example.com"}, info, issue); err == nil {
+		t.Fatal("expected web.example.com to be refused")
+	}
+	if err = a.record(ctx, auditRevoke, &pb.RevokeRequest{Serial: "01"}, "01", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = a.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readAuditEntries(t *testing.T, filename string) []auditEntry {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []auditEntry
+	for _, line := range bytes.Split(bytes.TrimSpace(b), []byte("\n")) {
+		var e auditEntry
+		if err = json.Unmarshal(line, &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestAuditLog(t *testing.T) {
+	c := newTestCA(t)
+
+	dir, err := ioutil.TempDir("", "certMgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "audit.log")
+
+	writeTestAuditLog(t, c, filename)
+
+	entries := readAuditEntries(t, filename)
+	if len(entries) != 5 {
+		t.Fatalf("expected 5 entries, got %d", len(entries))
+	}
+	if e := entries[1]; e.Event != auditCreate || e.Result != auditOK || e.Requester != "alice@dstcorp.com" ||
+		e.CorrelationID != "b5vrd2bqsa1g00aq3ba0" || len(e.Serial) == 0 || len(e.RequestHash) == 0 {
+		t.Errorf("unexpected issuance entry %+v", e)
+	}
+	if e := entries[2]; e.Result != auditDenied || !strings.Contains(e.Error, "web.example.com") {
+		t.Errorf("unexpected denial entry %+v", e)
+	}
+	if entries[1].RequestHash == entries[2].RequestHash {
+		t.Error("expected different requests to have different hashes")
+	}
+	if hashRequest(&pb.CreateRequest{Name: "web.dstcorp.io", Format: FormatPKCS12, Password: "secret"}) !=
+		hashRequest(&pb.CreateRequest{Name: "web.dstcorp.io", Format: FormatPKCS12}) {
+		t.Error("expected the keystore password to be left out of the request hash")
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := verifyAuditLog(f, &c.SigningCertificate)
+	f.Close()
+	if err != nil {
+		t.Fatalf("verify: %s", err)
+	}
+	// the CA load, the 3rd entry & the close are checkpoints
+	if v.Entries != 5 || v.Checkpoints != 3 || v.Unsigned != 0 {
+		t.Errorf("unexpected verification %+v", v)
+	}
+
+	// reopening continues the chain
+	writeTestAuditLog(t, c, filename)
+	f, err = os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err = verifyAuditLog(f, &c.SigningCertificate)
+	f.Close()
+	if err != nil || v.Entries != 10 {
+		t.Errorf("expected 10 verified entries, got %d: %v", v.Entries, err)
+	}
+}
+
+func TestAuditLogTampering(t *testing.T) {
+	c := newTestCA(t)
+
+	dir, err := ioutil.TempDir("", "certMgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "audit.log")
+
+	writeTestAuditLog(t, c, filename)
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(b), "\n")
+	entries := readAuditEntries(t, filename)
+
+	marshal := func(e auditEntry) string {
+		line, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(line) + "\n"
+	}
+
+	// the issuance attributed to someone else, with its hash recomputed
+	forged := entries[1]
+	forged.Requester = "mallory@dstcorp.com"
+	forged.Hash = forged.digest()
+
+	// the chain rewritten from the denial onwards, without the CA key
+	rewrite := func(keepSignatures bool) []string {
+		rewritten := append([]string{}, lines[:2]...)
+		prev := entries[1].Hash
+		for _, e := range entries[2:] {
+			if e.Seq == 3 {
+				e.Result, e.Error = auditOK, ""
+			}
+			if !keepSignatures {
+				e.Signature = ""
+			}
+			e.Prev = prev
+			e.Hash = e.digest()
+			prev = e.Hash
+			rewritten = append(rewritten, marshal(e))
+		}
+		return rewritten
+	}
+
+	// an unsigned CA load, turning off the checkpoints, inserted after the 1st checkpoint
+	// & followed by the rest of the chain, rewritten without signatures
+	forgeCALoad := func(result string) []string {
+		rewritten := append([]string{}, lines[:3]...)
+		load := auditEntry{Seq: 4, Time: entries[2].Time, Event: auditCALoad, CA: c.Name, Result: result, Prev: entries[2].Hash}
+		load.Hash = load.digest()
+		rewritten = append(rewritten, marshal(load))
+		prev := load.Hash
+		for _, e := range entries[3:] {
+			e.Seq++
+			e.Signature = ""
+			e.Prev = prev
+			e.Hash = e.digest()
+			prev = e.Hash
+			rewritten = append(rewritten, marshal(e))
+		}
+		return rewritten
+	}
+
+	other := newTestCA(t)
+
+	tests := []struct {
+		name    string
+		log     []string
+		cert    *ca
+		failure string
+	}{
+		{"altered field", replace(lines, 2, strings.Replace(lines[2], `"denied"`, `"ok"    `, 1)), c, "has been altered"},
+		{"altered entry", replace(lines, 2, strings.Replace(lines[2], `"denied"`, `"ok"`, 1)), c, "has been altered"},
+		{"forged entry", replace(lines, 1, marshal(forged)), c, "entry 3 does not follow entry 2"},
+		{"removed entry", append(append([]string{}, lines[:2]...), lines[3:]...), c, "entries were removed"},
+		{"removed first entry", lines[1:], c, "expected entry 1"},
+		{"reordered entries", []string{lines[0], lines[2], lines[1], lines[3], lines[4]}, c, "expected entry 2"},
+		{"rewritten chain", rewrite(false), c, "entry 3 is not a checkpoint"},
+		{"rewritten signed chain", rewrite(true), c, "entry 3 is not signed by"},
+		{"forged CA load", forgeCALoad(auditOK), c, "entry 4 records a CA load not signed"},
+		{"forged failed CA load", forgeCALoad(auditFailed), c, "entry 5 is not a checkpoint"},
+		{"another CA", lines, other, "not signed by"},
+	}
+
+	for _, test := range tests {
+		_, err := verifyAuditLog(strings.NewReader(strings.Join(test.log, "")), &test.cert.SigningCertificate)
+		if err == nil || !strings.Contains(err.Error(), test.failure) {
+			t.Errorf("%s: expected %q, got %v", test.name, test.failure, err)
+		}
+	}
+
+	// truncation after a checkpoint cannot be detected, but is reported
+	v, err := verifyAuditLog(strings.NewReader(strings.Join(lines[:4], "")), &c.SigningCertificate)
+	if err != nil || v.Entries != 4 || v.Unsigned != 1 {
+		t.Errorf("expected 4 entries, 1 after the last checkpoint, got %+v: %v", v, err)
+	}
+}
+
+func replace(lines []string, i int, line string) []string {
+	replaced := append([]string{}, lines...)
+	replaced[i] = line
+	return replaced
+}
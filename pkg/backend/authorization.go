@@ -0,0 +1,114 @@
+package backend
+
+import (
+	"path"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// the prefix of every CertMgr method name
+	certMgrServicePrefix = "/service.CertMgr/"
+
+	// an AuthorizedCreators entry with this prefix names a group, e.g. group:sre
+	groupEntryPrefix = "group:"
+)
+
+// creatorMethods issue or revoke certificates; only authorized creators may call them.
+// The remaining CertMgr methods may be called by any authenticated user.
+var creatorMethods = map[string]bool{
+	certMgrServicePrefix + "CreateCertificate":      true,
+	certMgrServicePrefix + "SignCertificateRequest": true,
+	certMgrServicePrefix + "RenewCertificate":       true,
+	certMgrServicePrefix + "RevokeCertificate":      true,
+}
+
+// authorizer decides which callers may create certificates. Entries are user IDs
+// or, when prefixed by "group:", group names. Either may be a wildcard pattern
+// such as * or *@dstcorp.com. An empty list permits every authenticated user.
+type authorizer struct {
+	users  []string
+	groups []string
+}
+
+func newAuthorizer(creators []string) *authorizer {
+	a := &authorizer{}
+
+	for _, entry := range creators {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case len(entry) == 0:
+		case strings.HasPrefix(entry, groupEntryPrefix):
+			a.groups = append(a.groups, strings.TrimPrefix(entry, groupEntryPrefix))
+		default:
+			a.users = append(a.users, entry)
+		}
+	}
+
+	return a
+}
+
+// permits returns true if the user, or one of the user's groups, is an authorized creator
+func (a *authorizer) permits(user string, groups []string) bool {
+	if len(a.users) == 0 && len(a.groups) == 0 {
+		return true
+	}
+
+	if matchesAny(a.users, user) {
+		return true
+	}
+
+	for _, group := range groups {
+		if matchesAny(a.groups, group) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesAny returns true if the name matches one of the (wildcard) patterns
+func matchesAny(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, name); ok && err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize checks the caller's identity before invoking the method
+func (a *authorizer) authorize(ctx context.Context, method string) error {
+	if !strings.HasPrefix(method, certMgrServicePrefix) {
+		return nil
+	}
+
+	user := requester(ctx)
+	if len(user) == 0 {
+		return status.Error(codes.Unauthenticated, "the caller has not been authenticated")
+	}
+
+	if creatorMethods[method] && !a.permits(user, requesterGroups(ctx)) {
+		log.WithFields(log.Fields{"requester": user, "method": method}).Warn("caller is not an authorized creator")
+		return status.Errorf(codes.PermissionDenied, "%s is not authorized to create certificates", user)
+	}
+
+	return nil
+}
+
+// UnaryServerInterceptor rejects calls from unauthenticated or unauthorized callers
+func (a *authorizer) UnaryServerInterceptor(ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	if err := a.authorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
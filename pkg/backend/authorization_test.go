@@ -0,0 +1,69 @@
+package backend
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthorizerPermits(t *testing.T) {
+	tests := []struct {
+		creators []string
+		user     string
+		groups   []string
+		expected bool
+	}{
+		{nil, "anyone@dstcorp.com", nil, true},
+		{[]string{""}, "anyone@dstcorp.com", nil, true},
+		{[]string{"alice@dstcorp.com"}, "Alice@DSTcorp.com", nil, true},
+		{[]string{"alice@dstcorp.com"}, "bob@dstcorp.com", nil, false},
+		{[]string{"*"}, "bob@dstcorp.com", nil, true},
+		{[]string{"*@dstcorp.com"}, "bob@dstcorp.com", nil, true},
+		{[]string{"*@dstcorp.com"}, "bob@example.com", nil, false},
+		{[]string{"group:sre"}, "bob@dstcorp.com", []string{"dev", "SRE"}, true},
+		{[]string{"group:sre"}, "bob@dstcorp.com", []string{"dev"}, false},
+		{[]string{"group:platform-*"}, "bob@dstcorp.com", []string{"platform-ops"}, true},
+		{[]string{"group:sre"}, "sre", nil, false},
+	}
+
+	for _, test := range tests {
+		a := newAuthorizer(test.creators)
+		if got := a.permits(test.user, test.groups); got != test.expected {
+			t.Errorf("%v permits %s %v: expected %t, got %t", test.creators, test.user, test.groups, test.expected, got)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	a := newAuthorizer([]string{"group:sre", "alice@dstcorp.com"})
+
+	caller := func(pairs ...string) context.Context {
+		return metadata.NewContext(context.Background(), metadata.Pairs(pairs...))
+	}
+
+	tests := []struct {
+		ctx      context.Context
+		method   string
+		expected codes.Code
+	}{
+		{context.Background(), certMgrServicePrefix + "CreateCertificate", codes.Unauthenticated},
+		{context.Background(), certMgrServicePrefix + "ListCertificates", codes.Unauthenticated},
+		{context.Background(), "/grpc.health.v1.Health/Check", codes.OK},
+		{caller(remoteUserMetadataKey, "alice@dstcorp.com"), certMgrServicePrefix + "CreateCertificate", codes.OK},
+		{caller(remoteUserMetadataKey, "bob@dstcorp.com"), certMgrServicePrefix + "CreateCertificate", codes.PermissionDenied},
+		{caller(remoteUserMetadataKey, "bob@dstcorp.com"), certMgrServicePrefix + "RevokeCertificate", codes.PermissionDenied},
+		{caller(remoteUserMetadataKey, "bob@dstcorp.com"), certMgrServicePrefix + "ListCertificates", codes.OK},
+		{caller(remoteUserMetadataKey, "bob@dstcorp.com", remoteGroupsMetadataKey, "dev, sre"),
+			certMgrServicePrefix + "SignCertificateRequest", codes.OK},
+	}
+
+	for _, test := range tests {
+		err := a.authorize(test.ctx, test.method)
+		if code := status.Code(err); code != test.expected {
+			t.Errorf("%s by %s: expected %s, got %s", test.method, requester(test.ctx), test.expected, code)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"os/signal"
 	"syscall"
 	"text/template"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/grpc-ecosystem/go-grpc-middleware"
@@ -24,8 +25,11 @@ import (
 )
 
 type server struct {
-	cfg certMgr.AppConfig
-	ca  *ca
+	cfg   certMgr.AppConfig
+	ca    *ca
+	cas   []*ca
+	store CertificateStore
+	audit *auditLog
 }
 
 func grpcEndpointLog(s string) grpc.UnaryServerInterceptor {
@@ -53,8 +57,89 @@ func Run(cfg *certMgr.AppConfig) {
 		log.Fatal(err)
 	}
 
-	// create the Certificate Authority
-	server.ca, err = NewCertificateAuthorityFromConfig(cfg)
+	// open the inventory of issued certificates
+	server.store, err = NewCertificateStore(cfg)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to open the certificate store")
+	}
+	defer server.store.Close()
+
+	// record every CA operation in the audit log
+	server.audit, err = openAuditLog(cfg.Backend.AuditFilename, cfg.Backend.AuditInterval)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to open the audit log")
+	}
+	defer server.audit.Close()
+
+	// create the Certificate Authorities
+	cas, caConfigs, err := NewCertificateAuthoritiesFromConfig(cfg)
+	if err != nil {
+		server.audit.recordCALoad(nil, cfg.Backend, err)
+		log.WithError(err).Fatal("Unable to create the certificate authority")
+	}
+	server.ca, server.cas = cas[0], cas
+	server.audit.setSigner(server.ca.Name, server.ca.SigningKey)
+
+	for i, c := range cas {
+		caCfg := caConfigs[i].Backend
+		c.Store = server.store
+
+		// restrict the names each user or group may request
+		if len(caCfg.PolicyFilename) > 0 {
+			c.Policy, err = loadDomainPolicy(caCfg.PolicyFilename)
+			if err != nil {
+				server.audit.recordCALoad(c, caCfg, err)
+				log.WithError(err).WithField("ca", c.Name).Fatal("Unable to load the policy")
+			}
+		}
+
+		if err = server.audit.recordCALoad(c, caCfg, nil); err != nil {
+			log.WithError(err).Fatal("Unable to record the certificate authority in the audit log")
+		}
+
+		// publish the certificate revocation list
+		c.CRL, err = newCRLPublisher(c, server.store,
+			caCfg.CRLNumberFilename,
+			time.Duration(caCfg.CRLValidity)*time.Hour)
+		if err != nil {
+			log.WithError(err).WithField("ca", c.Name).Fatal("Unable to initialize CRL publication")
+		}
+		if err = c.CRL.generate(context.Background()); err != nil {
+			log.WithError(err).WithField("ca", c.Name).Fatal("Unable to generate the CRL")
+		}
+		go c.CRL.run(time.Duration(caCfg.CRLRefreshInterval) * time.Hour)
+	}
+
+	// publish every issued certificate in the transparency log, whose tree heads the primary CA signs
+	if len(cfg.Backend.CTLogFilename) > 0 {
+		ctLog, err := newTransparencyLog(server.ca, cfg.Backend.CTLogFilename)
+		if err != nil {
+			log.WithError(err).Fatal("Unable to open the transparency log")
+		}
+		defer ctLog.Close()
+
+		if err = ctLog.publish(); err != nil {
+			log.WithError(err).Fatal("Unable to publish the transparency log's tree head")
+		}
+		go ctLog.run(time.Duration(cfg.Backend.CTPublishInterval) * time.Minute)
+
+		for _, c := range cas {
+			c.CT = ctLog
+		}
+	}
+
+	// answer OCSP requests with a delegated responder certificate of each CA
+	var ocspResponders ocspMux
+	if len(cfg.Backend.OCSPListenAddress) > 0 {
+		for _, c := range cas {
+			c.OCSP = newOCSPResponder(c, server.store,
+				time.Duration(cfg.Backend.OCSPValidity)*time.Hour)
+			ocspResponders = append(ocspResponders, c.OCSP)
+		}
+	}
+
+	// only authenticated users may call the service, and only authorized creators may issue certificates
+	auth := newAuthorizer(cfg.Backend.AuthorizedCreators)
 
 	// make a channel to listen on events,
 	// then launch the servers.
@@ -83,6 +168,8 @@ func Run(cfg *certMgr.AppConfig) {
 			s = grpc.NewServer(
 				grpc_middleware.WithUnaryServerChain(
 					grpc_prometheus.UnaryServerInterceptor,
+					server.audit.UnaryServerInterceptor,
+					auth.UnaryServerInterceptor,
 					grpcEndpointLog("certMgr")))
 		} else {
 			tlsCreds, err := credentials.NewServerTLSFromFile(cfg.CertFilename, cfg.KeyFilename)
@@ -95,6 +182,8 @@ func Run(cfg *certMgr.AppConfig) {
 				grpc.RPCDecompressor(grpc.NewGZIPDecompressor()),
 				grpc_middleware.WithUnaryServerChain(
 					grpc_prometheus.UnaryServerInterceptor,
+					server.audit.UnaryServerInterceptor,
+					auth.UnaryServerInterceptor,
 					grpcEndpointLog("certMgr")))
 		}
 
@@ -117,6 +206,12 @@ func Run(cfg *certMgr.AppConfig) {
 		}
 
 		http.Handle("/healthz", healthzHandler)
+		for _, c := range cas {
+			http.Handle(c.CRL.path(), c.CRL)
+		}
+		if server.ca.CT != nil {
+			http.Handle(ctPathPrefix, server.ca.CT)
+		}
 		http.Handle("/metrics", prometheus.Handler())
 		http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 			type data struct {
@@ -152,6 +247,14 @@ func Run(cfg *certMgr.AppConfig) {
 		}
 	}()
 
+	// OCSP responder
+	if len(ocspResponders) > 0 {
+		go func() {
+			log.Infof("OCSP responder listening on %s", cfg.Backend.OCSPListenAddress)
+			errc <- http.ListenAndServe(cfg.Backend.OCSPListenAddress, ocspResponders)
+		}()
+	}
+
 	// wait for somthin'
 	log.Infof("exit: %s", <-errc)
 }
@@ -10,6 +10,7 @@ import (
 	pb "github.com/mchudgins/certMgr/pkg/service"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
 )
 
 var (
@@ -34,6 +35,11 @@ func createConnection() (pb.CertMgrClient, *grpc.ClientConn, error) {
 	return c, conn, nil
 }
 
+// authenticatedContext identifies the caller as the frontend's security proxy would
+func authenticatedContext() context.Context {
+	return metadata.NewContext(context.Background(), metadata.Pairs(remoteUserMetadataKey, "backend_test"))
+}
+
 func TestCreate(t *testing.T) {
 	c, conn, err := createConnection()
 	if err != nil {
@@ -43,7 +49,7 @@ func TestCreate(t *testing.T) {
 
 	// Contact the server and print out its response.
 	name := defaultName
-	r, err := c.CreateCertificate(context.Background(), &pb.CreateRequest{Name: name, Duration: 90})
+	r, err := c.CreateCertificate(authenticatedContext(), &pb.CreateRequest{Name: name, Duration: 90})
 	if err != nil {
 		log.WithError(err).Fatalf("could not create certificate: %v", err)
 	}
@@ -67,7 +73,7 @@ func TestLotsOfCreates(t *testing.T) {
 	for i := 0; i < loopers; i++ {
 		go func() {
 			for j := 0; j < 10; j++ {
-				_, err := c.CreateCertificate(context.Background(), &pb.CreateRequest{Name: name, Duration: 90})
+				_, err := c.CreateCertificate(authenticatedContext(), &pb.CreateRequest{Name: name, Duration: 90})
 				if err != nil {
 					log.WithError(err).Fatalf("could not create certificate: %v", err)
 				}
@@ -0,0 +1,156 @@
+package backend
+
+import (
+	"encoding/json"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/net/context"
+)
+
+var (
+	certificatesBucket = []byte("certificates")
+)
+
+// boltStore is a CertificateStore kept in an embedded BoltDB file.
+// Records are JSON encoded and keyed by serial number.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens (or creates) the BoltDB certificate store
+func NewBoltStore(filename string) (CertificateStore, error) {
+	db, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		log.WithError(err).WithField("file", filename).Error("Unable to open the certificate store")
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(certificatesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltStore{db: db}, nil
+}
+
+func (b *boltStore) Insert(ctx context.Context, rec *CertificateRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(certificatesBucket)
+		key := []byte(normalizeSerial(rec.Serial))
+		if bucket.Get(key) != nil {
+			return ErrCertificateExists
+		}
+		return bucket.Put(key, data)
+	})
+}
+
+func (b *boltStore) Get(ctx context.Context, serial string) (*CertificateRecord, error) {
+	var rec *CertificateRecord
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(certificatesBucket).Get([]byte(normalizeSerial(serial)))
+		if data == nil {
+			return ErrCertificateNotFound
+		}
+
+		rec = &CertificateRecord{}
+		return json.Unmarshal(data, rec)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+func (b *boltStore) List(ctx context.Context,
+	filter *CertificateFilter,
+	pageToken string,
+	pageSize int) ([]*CertificateRecord, string, error) {
+	var page []*CertificateRecord
+	var nextPageToken string
+
+	pageSize = normalizePageSize(pageSize)
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(certificatesBucket).Cursor()
+
+		for k, v := c.Seek([]byte(pageToken)); k != nil; k, v = c.Next() {
+			if string(k) == pageToken {
+				continue
+			}
+
+			rec := &CertificateRecord{}
+			if err := json.Unmarshal(v, rec); err != nil {
+				return err
+			}
+			if !filter.matches(rec) {
+				continue
+			}
+
+			if len(page) == pageSize {
+				nextPageToken = normalizeSerial(page[len(page)-1].Serial)
+				return nil
+			}
+			page = append(page, rec)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return page, nextPageToken, nil
+}
+
+func (b *boltStore) Update(ctx context.Context,
+	serial string,
+	update func(rec *CertificateRecord) error) (*CertificateRecord, error) {
+	var rec *CertificateRecord
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(certificatesBucket)
+		key := []byte(normalizeSerial(serial))
+
+		data := bucket.Get(key)
+		if data == nil {
+			return ErrCertificateNotFound
+		}
+
+		rec = &CertificateRecord{}
+		if err := json.Unmarshal(data, rec); err != nil {
+			return err
+		}
+
+		if err := update(rec); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, data)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+func (b *boltStore) Close() error {
+	return b.db.Close()
+}
@@ -0,0 +1,52 @@
+package backend
+
+import (
+	pb "github.com/mchudgins/certMgr/pkg/service"
+	"golang.org/x/net/context"
+)
+
+// GetCAInfo describes the named (by default, the primary) CA, so that clients can
+// learn what it will issue before asking
+func (s *server) GetCAInfo(ctx context.Context, in *pb.CAInfoRequest) (*pb.CAInfoReply, error) {
+	c, err := s.lookupCA(ctx, in.GetCa())
+	if err != nil {
+		return nil, err
+	}
+
+	reply := c.info()
+	reply.Cas = s.caNames()
+	return reply, nil
+}
+
+func (c *ca) info() *pb.CAInfoReply {
+	cert := &c.SigningCertificate
+
+	reply := &pb.CAInfoReply{
+		Name:             c.Name,
+		Subject:          cert.Subject.String(),
+		PermittedDomains: cert.PermittedDNSDomains,
+		ExcludedDomains:  cert.ExcludedDNSDomains,
+		MaxDuration:      int64(c.MaxDuration.Hours() / 24),
+		Chain:            c.Bundle,
+		Root:             c.rootPEM(),
+		NotBefore:        cert.NotBefore.Unix(),
+		NotAfter:         cert.NotAfter.Unix(),
+	}
+
+	for _, name := range c.profileNames() {
+		if _, err := c.lookupProfile(name); err == nil {
+			reply.Profiles = append(reply.Profiles, name)
+		}
+	}
+	if prof, err := c.lookupProfile(""); err == nil {
+		reply.DefaultProfile = prof.Name
+	}
+
+	for _, keyType := range SupportedKeyTypes {
+		if c.permitsKeyType(keyType) {
+			reply.KeyTypes = append(reply.KeyTypes, keyType)
+		}
+	}
+
+	return reply
+}
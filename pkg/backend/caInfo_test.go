@@ -0,0 +1,56 @@
+package backend
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	pb "github.com/mchudgins/certMgr/pkg/service"
+	"golang.org/x/net/context"
+)
+
+func TestCAInfo(t *testing.T) {
+	c := newTestCA(t)
+	c.MaxDuration = 90 * 24 * time.Hour
+	c.KeyTypes = []string{"RSA-2048", KeyTypeECDSAP256}
+	c.AllowedProfiles = []string{"server", "client"}
+	c.DefaultProfile = "server"
+
+	s := &server{ca: c}
+	info, err := s.GetCAInfo(context.Background(), &pb.CAInfoRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Name != "test" || info.Subject != c.SigningCertificate.Subject.String() {
+		t.Errorf("unexpected CA %s (%s)", info.Name, info.Subject)
+	}
+	if !reflect.DeepEqual(info.PermittedDomains, []string{"dstcorp.io"}) || len(info.ExcludedDomains) != 0 {
+		t.Errorf("unexpected domains %v, excluding %v", info.PermittedDomains, info.ExcludedDomains)
+	}
+	if info.MaxDuration != 90 {
+		t.Errorf("expected a maximum of 90 days, got %d", info.MaxDuration)
+	}
+	if !reflect.DeepEqual(info.Profiles, []string{"client", "server"}) || info.DefaultProfile != "server" {
+		t.Errorf("unexpected profiles %v (default %s)", info.Profiles, info.DefaultProfile)
+	}
+	if !reflect.DeepEqual(info.KeyTypes, []string{KeyTypeECDSAP256, KeyTypeRSA2048}) {
+		t.Errorf("unexpected key types %v", info.KeyTypes)
+	}
+	if info.NotBefore != c.SigningCertificate.NotBefore.Unix() || info.NotAfter != c.SigningCertificate.NotAfter.Unix() {
+		t.Errorf("unexpected validity %d - %d", info.NotBefore, info.NotAfter)
+	}
+
+	// the test CA is its own root
+	if len(info.Chain) != 0 || !parseTestCertificate(t, info.Root).Equal(&c.SigningCertificate) {
+		t.Error("expected the signing certificate as the root, without intermediates")
+	}
+
+	// without allowlists, every key type & the default profiles are available
+	c.KeyTypes, c.AllowedProfiles = nil, nil
+	info = c.info()
+	if !reflect.DeepEqual(info.KeyTypes, SupportedKeyTypes) ||
+		!reflect.DeepEqual(info.Profiles, []string{"client", "email", "mtls", "server"}) {
+		t.Errorf("expected every key type & the default profiles, got %v & %v", info.KeyTypes, info.Profiles)
+	}
+}
@@ -0,0 +1,180 @@
+package backend
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/mchudgins/certMgr/pkg/certMgr"
+	log "github.com/sirupsen/logrus"
+	"github.com/youmark/pkcs8"
+)
+
+// DefaultPassphraseEnv is the environment variable consulted for the CA key's passphrase
+const DefaultPassphraseEnv = "CERTMGR_CA_PASSPHRASE"
+
+var (
+	// ErrPassphraseRequired is returned for an encrypted CA key when no passphrase is available
+	ErrPassphraseRequired = errors.New("the CA key is encrypted, but no passphrase was provided")
+	// ErrIncorrectPassphrase is returned when the CA key cannot be decrypted with the passphrase
+	ErrIncorrectPassphrase = errors.New("the CA key's passphrase is incorrect")
+)
+
+// PassphraseFunc supplies the passphrase of an encrypted CA key. It is only
+// called when the key is encrypted.
+type PassphraseFunc func() ([]byte, error)
+
+// EnvPassphrase reads the passphrase from the environment variable
+func EnvPassphrase(name string) PassphraseFunc {
+	return func() ([]byte, error) {
+		value, ok := os.LookupEnv(name)
+		if !ok || len(value) == 0 {
+			return nil, ErrPassphraseRequired
+		}
+		return []byte(value), nil
+	}
+}
+
+// FilePassphrase reads the passphrase from a file, such as a mounted secret.
+// A trailing newline is ignored.
+func FilePassphrase(filename string) PassphraseFunc {
+	return func() ([]byte, error) {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			log.WithError(err).WithField("file", filename).Error("Unable to read the CA key's passphrase")
+			return nil, err
+		}
+		return bytes.TrimRight(data, "\r\n"), nil
+	}
+}
+
+// FirstPassphrase tries each source in turn, returning the first passphrase available
+func FirstPassphrase(sources ...PassphraseFunc) PassphraseFunc {
+	return func() ([]byte, error) {
+		for _, source := range sources {
+			if source == nil {
+				continue
+			}
+			passphrase, err := source()
+			if err == ErrPassphraseRequired {
+				continue
+			}
+			return passphrase, err
+		}
+		return nil, ErrPassphraseRequired
+	}
+}
+
+// PassphraseFromConfig returns the configured passphrase sources: the passphrase
+// file, if any, then the environment variable
+func PassphraseFromConfig(cfg *certMgr.AppConfig) PassphraseFunc {
+	var file PassphraseFunc
+	if len(cfg.Backend.CAKeyPassphraseFile) > 0 {
+		file = FilePassphrase(cfg.Backend.CAKeyPassphraseFile)
+	}
+
+	env := cfg.Backend.CAKeyPassphraseEnv
+	if len(env) == 0 {
+		env = DefaultPassphraseEnv
+	}
+
+	return FirstPassphrase(file, EnvPassphrase(env))
+}
+
+// parseCAKey decodes the CA's private key, decrypting it if necessary.
+// PKCS#1 (RSA PRIVATE KEY), SEC1 (EC PRIVATE KEY) and PKCS#8 (PRIVATE KEY) keys are
+// supported, as are encrypted PKCS#8 (PBES2) and legacy (Proc-Type: 4,ENCRYPTED) PEM keys.
+func parseCAKey(block *pem.Block, passphrase PassphraseFunc) (crypto.Signer, error) {
+	der := block.Bytes
+	blockType := block.Type
+
+	encrypted := blockType == "ENCRYPTED PRIVATE KEY" || x509.IsEncryptedPEMBlock(block)
+
+	var pass []byte
+	if encrypted {
+		if passphrase == nil {
+			return nil, ErrPassphraseRequired
+		}
+
+		var err error
+		pass, err = passphrase()
+		if err != nil {
+			return nil, err
+		}
+		if len(pass) == 0 {
+			return nil, ErrPassphraseRequired
+		}
+	}
+
+	var key interface{}
+	var err error
+
+	switch {
+	case blockType == "ENCRYPTED PRIVATE KEY":
+		key, err = pkcs8.ParsePKCS8PrivateKey(der, pass)
+		if err != nil && strings.Contains(err.Error(), "incorrect password") {
+			return nil, ErrIncorrectPassphrase
+		}
+
+	case x509.IsEncryptedPEMBlock(block):
+		der, err = x509.DecryptPEMBlock(block, pass)
+		if err == x509.IncorrectPasswordError {
+			return nil, ErrIncorrectPassphrase
+		}
+		if err == nil {
+			key, err = parsePrivateKeyDER(blockType, der)
+			if err != nil {
+				// legacy PEM encryption has no integrity check, so a wrong
+				// passphrase usually surfaces as an unparseable key
+				return nil, ErrIncorrectPassphrase
+			}
+		}
+
+	default:
+		key, err = parsePrivateKeyDER(blockType, der)
+	}
+	if err != nil {
+		log.WithError(err).WithField("type", blockType).Error("Unable to parse the CA key")
+		return nil, fmt.Errorf("Unable to parse the CA key: %s", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("the CA key is not a crypto.Signer")
+	}
+
+	return signer, nil
+}
+
+// parsePrivateKeyDER parses a decrypted private key according to its PEM block type
+func parsePrivateKeyDER(blockType string, der []byte) (interface{}, error) {
+	switch blockType {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(der)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(der)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(der)
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", blockType)
+	}
+}
+
+// checkKeyMatchesCertificate verifies the CA key is the private half of the CA certificate's
+// public key; a mismatch would otherwise only surface as unverifiable signatures
+func checkKeyMatchesCertificate(key crypto.Signer, cert *x509.Certificate) error {
+	pub, ok := key.Public().(interface {
+		Equal(crypto.PublicKey) bool
+	})
+	if !ok || !pub.Equal(cert.PublicKey) {
+		return fmt.Errorf("the CA key does not match the public key of the CA certificate %s",
+			cert.Subject.CommonName)
+	}
+	return nil
+}
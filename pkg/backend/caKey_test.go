@@ -0,0 +1,175 @@
+package backend
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youmark/pkcs8"
+)
+
+func staticPassphrase(passphrase string) PassphraseFunc {
+	return func() ([]byte, error) {
+		return []byte(passphrase), nil
+	}
+}
+
+func TestEncryptedCAKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pbes2DER, err := pkcs8.MarshalPrivateKey(ecKey, []byte("s3cret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbes2 := &pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: pbes2DER}
+
+	legacy, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY",
+		x509.MarshalPKCS1PrivateKey(rsaKey), []byte("s3cret"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, block := range map[string]*pem.Block{"PBES2": pbes2, "legacy": legacy} {
+		signer, err := parseCAKey(block, staticPassphrase("s3cret"))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		} else if signer == nil {
+			t.Errorf("%s: no signer returned", name)
+		}
+
+		if _, err = parseCAKey(block, staticPassphrase("guess")); err != ErrIncorrectPassphrase {
+			t.Errorf("%s, wrong passphrase: expected ErrIncorrectPassphrase, got %v", name, err)
+		}
+
+		if _, err = parseCAKey(block, nil); err != ErrPassphraseRequired {
+			t.Errorf("%s, no passphrase: expected ErrPassphraseRequired, got %v", name, err)
+		}
+		if _, err = parseCAKey(block, EnvPassphrase("CERTMGR_TEST_UNSET_PASSPHRASE")); err != ErrPassphraseRequired {
+			t.Errorf("%s, unset variable: expected ErrPassphraseRequired, got %v", name, err)
+		}
+	}
+
+	// an unencrypted key never asks for the passphrase
+	keyDER, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asked := false
+	_, err = parseCAKey(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}, func() ([]byte, error) {
+		asked = true
+		return nil, nil
+	})
+	if err != nil || asked {
+		t.Errorf("unencrypted key: err %v, passphrase requested %t", err, asked)
+	}
+}
+
+func TestPassphraseSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certMgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "passphrase")
+	if err = ioutil.WriteFile(filename, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("CERTMGR_TEST_PASSPHRASE", "from-env")
+	defer os.Unsetenv("CERTMGR_TEST_PASSPHRASE")
+
+	tests := []struct {
+		source   PassphraseFunc
+		expected string
+	}{
+		{FilePassphrase(filename), "from-file"},
+		{EnvPassphrase("CERTMGR_TEST_PASSPHRASE"), "from-env"},
+		{FirstPassphrase(nil, EnvPassphrase("CERTMGR_TEST_UNSET_PASSPHRASE"), EnvPassphrase("CERTMGR_TEST_PASSPHRASE")), "from-env"},
+		{FirstPassphrase(FilePassphrase(filename), EnvPassphrase("CERTMGR_TEST_PASSPHRASE")), "from-file"},
+	}
+
+	for i, test := range tests {
+		passphrase, err := test.source()
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+		} else if string(passphrase) != test.expected {
+			t.Errorf("%d: expected %q, got %q", i, test.expected, passphrase)
+		}
+	}
+
+	if _, err = FirstPassphrase(EnvPassphrase("CERTMGR_TEST_UNSET_PASSPHRASE"))(); err != ErrPassphraseRequired {
+		t.Errorf("expected ErrPassphraseRequired, got %v", err)
+	}
+}
+
+func TestCAKeyFormats(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := time.Now().Add(24 * time.Hour)
+	ecCert := []byte(newTestCACertificate(t, "test-ca.dstcorp.io", ecKey, nil, valid).pem())
+	rsaCert := []byte(newTestCACertificate(t, "test-ca.dstcorp.io", rsaKey, nil, valid).pem())
+
+	sec1, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPKCS8, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPKCS8, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cert []byte
+		key  *pem.Block
+	}{
+		{"PKCS#1", rsaCert, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}},
+		{"SEC1", ecCert, &pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}},
+		{"PKCS#8 EC", ecCert, &pem.Block{Type: "PRIVATE KEY", Bytes: ecPKCS8}},
+		{"PKCS#8 RSA", rsaCert, &pem.Block{Type: "PRIVATE KEY", Bytes: rsaPKCS8}},
+	}
+
+	for _, test := range tests {
+		if _, err := createCA("test", test.cert, pem.EncodeToMemory(test.key), "", nil); err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		}
+	}
+
+	// the key must belong to the certificate
+	_, err = createCA("test", rsaCert, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}), "", nil)
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("expected a key mismatch, got %v", err)
+	}
+
+	_, err = createCA("test", ecCert, pem.EncodeToMemory(&pem.Block{Type: "DSA PRIVATE KEY", Bytes: sec1}), "", nil)
+	if err == nil || !strings.Contains(err.Error(), "unsupported key type") {
+		t.Errorf("expected an unsupported key type, got %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package backend
+
+import (
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// authorities returns every CA hosted by the backend, the primary first
+func (s *server) authorities() []*ca {
+	if len(s.cas) == 0 {
+		return []*ca{s.ca}
+	}
+	return s.cas
+}
+
+// caNames returns the names of the hosted CAs
+func (s *server) caNames() []string {
+	var names []string
+	for _, c := range s.authorities() {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+// lookupCA finds the named CA; an empty name selects the primary CA
+func (s *server) lookupCA(ctx context.Context, name string) (*ca, error) {
+	if len(name) == 0 {
+		noteAuditedCA(ctx, s.ca.Name)
+		return s.ca, nil
+	}
+
+	for _, c := range s.authorities() {
+		if c.Name == name {
+			noteAuditedCA(ctx, c.Name)
+			return c, nil
+		}
+	}
+	return nil, status.Errorf(codes.InvalidArgument, "CA %s is not hosted here (available CAs: %s)",
+		name, strings.Join(s.caNames(), ", "))
+}
+
+// selectCA returns the named CA or, when the request does not name one, the CA
+// whose name constraints cover every requested name. A CA whose chain constrains
+// the names it may issue is preferred to one whose chain does not; otherwise the
+// CAs are considered in the order they were configured. Malformed names are left
+// for the primary CA to refuse.
+func (s *server) selectCA(ctx context.Context, name string, names []string) (*ca, error) {
+	cas := s.authorities()
+	if len(name) > 0 || len(cas) == 1 || len(names) == 0 {
+		return s.lookupCA(ctx, name)
+	}
+
+	values := make([]string, len(names))
+	for i, n := range names {
+		value, err := normalizeName(n, i == 0)
+		if err != nil {
+			return s.lookupCA(ctx, "")
+		}
+		values[i] = value
+	}
+
+	var unconstrained *ca
+	for _, c := range cas {
+		if !c.coversNames(values) {
+			continue
+		}
+		if c.isConstrained() {
+			noteAuditedCA(ctx, c.Name)
+			return c, nil
+		}
+		if unconstrained == nil {
+			unconstrained = c
+		}
+	}
+	if unconstrained != nil {
+		noteAuditedCA(ctx, unconstrained.Name)
+		return unconstrained, nil
+	}
+
+	return nil, policyErrorf("%s: refused, not within the name constraints of any CA (%s)",
+		strings.Join(names, ", "), strings.Join(s.caNames(), ", "))
+}
+
+// issuingCA returns the hosted CA which issued the inventoried certificate. The
+// primary CA is returned for an unknown certificate, which it will fail to find.
+func (s *server) issuingCA(ctx context.Context, serial string) *ca {
+	if len(s.cas) > 1 && s.store != nil {
+		if rec, err := s.store.Get(ctx, serial); err == nil {
+			for _, c := range s.cas {
+				if c.Name == rec.CA {
+					noteAuditedCA(ctx, c.Name)
+					return c
+				}
+			}
+		}
+	}
+
+	noteAuditedCA(ctx, s.ca.Name)
+	return s.ca
+}
+
+// coversNames reports whether every (normalized) name lies within the name
+// constraints of the CA's chain
+func (c *ca) coversNames(values []string) bool {
+	for _, cert := range c.constrainingCertificates() {
+		for _, value := range values {
+			if checkNameConstraints(cert, value) != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isConstrained reports whether the CA's chain restricts the names it may issue
+func (c *ca) isConstrained() bool {
+	for _, cert := range c.constrainingCertificates() {
+		if len(cert.PermittedDNSDomains) > 0 || len(cert.PermittedIPRanges) > 0 ||
+			len(cert.PermittedEmailAddresses) > 0 || len(cert.PermittedURIDomains) > 0 {
+			return true
+		}
+	}
+	return false
+}
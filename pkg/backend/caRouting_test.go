@@ -0,0 +1,236 @@
+package backend
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mchudgins/certMgr/pkg/certMgr"
+	pb "github.com/mchudgins/certMgr/pkg/service"
+	"golang.org/x/crypto/ocsp"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCARouting(t *testing.T) {
+	ctx := context.Background()
+	valid := time.Now().Add(365 * 24 * time.Hour)
+	corp := newTestCACertificate(t, "corp-ca.dstcorp.io", nil, nil, valid, "dstcorp.io").ca(t, "corp", "")
+	lab := newTestCACertificate(t, "lab-ca.dstcorp.io", nil, nil, valid, "example.com").ca(t, "lab", "")
+	open := newTestCACertificate(t, "open-ca.dstcorp.io", nil, nil, valid).ca(t, "open", "")
+
+	store := NewMemoryStore()
+	for _, c := range []*ca{corp, lab, open} {
+		c.Store = store
+	}
+	s := &server{ca: corp, cas: []*ca{open, corp, lab}, store: store}
+
+	tests := []struct {
+		ca    string
+		names []string
+		want  string
+	}{
+		{"", []string{"api.dstcorp.io"}, "corp"},
+		{"", []string{"api.example.com", "www2.example.com"}, "lab"},
+		{"", []string{"api.other.org"}, "open"},
+		{"", []string{"api.dstcorp.io", "api.example.com"}, "open"},
+		{"open", []string{"api.dstcorp.io"}, "open"},
+	}
+	issued := map[string]string{}
+	for _, test := range tests {
+		reply, err := s.CreateCertificate(ctx, &pb.CreateRequest{Ca: test.ca, Name: test.names[0],
+			AlternateNames: test.names[1:], Duration: 1})
+		if err != nil {
+			t.Errorf("%v: %s", test.names, err)
+			continue
+		}
+		if reply.Ca != test.want {
+			t.Errorf("%v: expected CA %s, got %s", test.names, test.want, reply.Ca)
+		}
+		cert := parseTestCertificate(t, reply.GetCertificate())
+		if cert.Issuer.CommonName != test.want+"-ca.dstcorp.io" {
+			t.Errorf("%v: issued by %s", test.names, cert.Issuer.CommonName)
+		}
+		issued[test.want] = SerialString(cert.SerialNumber)
+	}
+
+	if _, err := s.CreateCertificate(ctx, &pb.CreateRequest{Ca: "nope", Name: "api.dstcorp.io", Duration: 1}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected an unknown CA to be refused, got %v", err)
+	}
+
+	// without an unconstrained CA, names outside every CA's constraints are refused
+	s.cas = []*ca{corp, lab}
+	_, err := s.CreateCertificate(ctx, &pb.CreateRequest{Name: "api.dstcorp.io", AlternateNames: []string{"api.example.com"}, Duration: 1})
+	if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), "not within the name constraints of any CA") {
+		t.Errorf("expected the names to be refused, got %v", err)
+	}
+
+	entitlements, err := s.CheckEntitlements(ctx, &pb.EntitlementsRequest{Names: []string{"api.example.com"}})
+	if err != nil || !entitlements.Permitted || entitlements.Ca != "lab" {
+		t.Errorf("expected the lab CA to permit api.example.com, got %+v (%v)", entitlements, err)
+	}
+
+	// revocation & renewal are handled by the issuing CA
+	auditCtx := withAuditedCA(ctx)
+	if _, err = s.RevokeCertificate(auditCtx, &pb.RevokeRequest{Serial: issued["lab"]}); err != nil {
+		t.Fatal(err)
+	}
+	if auditedCA(auditCtx) != "lab" {
+		t.Errorf("expected the revocation to be audited against lab, got %s", auditedCA(auditCtx))
+	}
+	if rec, err := store.Get(ctx, issued["lab"]); err != nil || !rec.Revoked {
+		t.Errorf("expected %s to be revoked (%v)", issued["lab"], err)
+	}
+
+	renewed, err := s.RenewCertificate(ctx, &pb.RenewRequest{Serial: issued["corp"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert := parseTestCertificate(t, renewed.Certificate); cert.Issuer.CommonName != "corp-ca.dstcorp.io" {
+		t.Errorf("expected corp to renew its certificate, got %s", cert.Issuer.CommonName)
+	}
+
+	info, err := s.GetCAInfo(ctx, &pb.CAInfoRequest{Ca: "lab"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "lab" || strings.Join(info.Cas, ",") != "corp,lab" {
+		t.Errorf("unexpected CA info %s (%v)", info.Name, info.Cas)
+	}
+}
+
+func TestOCSPMux(t *testing.T) {
+	ctx := context.Background()
+	valid := time.Now().Add(365 * 24 * time.Hour)
+	corp := newTestCACertificate(t, "corp-ca.dstcorp.io", nil, nil, valid, "dstcorp.io").ca(t, "corp", "")
+	lab := newTestCACertificate(t, "lab-ca.dstcorp.io", nil, nil, valid, "example.com").ca(t, "lab", "")
+	other := newTestCACertificate(t, "other-ca.dstcorp.io", nil, nil, valid).ca(t, "other", "")
+
+	store := NewMemoryStore()
+	var mux ocspMux
+	for _, c := range []*ca{corp, lab} {
+		c.Store = store
+		c.OCSP = newOCSPResponder(c, store, time.Hour)
+		mux = append(mux, c.OCSP)
+	}
+
+	query := func(certPEM string, issuer *ca) []byte {
+		cert := parseTestCertificate(t, certPEM)
+		req, err := ocsp.CreateRequest(cert, &issuer.SigningCertificate, &ocsp.RequestOptions{Hash: crypto.SHA1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("POST", "/", bytes.NewReader(req)))
+		return w.Body.Bytes()
+	}
+
+	// each CA answers for the certificates it issued
+	for _, c := range []*ca{corp, lab} {
+		cn := "api." + c.SigningCertificate.PermittedDNSDomains[0]
+		certPEM, _, err := c.CreateCertificate(ctx, cn, nil, 24*time.Hour, "", "", pkix.Name{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := ocsp.ParseResponseForCert(query(certPEM, c), parseTestCertificate(t, certPEM), &c.SigningCertificate)
+		if err != nil {
+			t.Fatalf("%s: %s", c.Name, err)
+		}
+		if resp.Status != ocsp.Good {
+			t.Errorf("%s: expected Good, got %d", c.Name, resp.Status)
+		}
+	}
+
+	// a CA not hosted here is unauthorized
+	certPEM, _, err := other.CreateCertificate(ctx, "api.other.org", nil, 24*time.Hour, "", "", pkix.Name{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(query(certPEM, other), ocsp.UnauthorizedErrorResponse) {
+		t.Error("expected an unauthorized response for a foreign CA")
+	}
+}
+
+func TestCAConfiguration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certMgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	valid := time.Now().Add(365 * 24 * time.Hour)
+	corpCA := newTestCACertificate(t, "corp-ca.dstcorp.io", nil, nil, valid, "dstcorp.io")
+	corpCert, corpKey := corpCA.pem(), corpCA.keyPEM(t)
+	labCA := newTestCACertificate(t, "lab-ca.dstcorp.io", nil, nil, valid, "example.com")
+	labCert, labKey := labCA.pem(), labCA.keyPEM(t)
+	personalCA := newTestCACertificate(t, "personal-ca.dstcorp.io", nil, nil, valid)
+	personalCert, personalKey := personalCA.pem(), personalCA.keyPEM(t)
+	corpKeyFile, labKeyFile := filepath.Join(dir, "corp.key"), filepath.Join(dir, "lab.key")
+	personalKeyFile := filepath.Join(dir, "personal.key")
+	for filename, key := range map[string]string{corpKeyFile: corpKey, labKeyFile: labKey, personalKeyFile: personalKey} {
+		if err = ioutil.WriteFile(filename, []byte(key), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	week, unlimited, enabled := 7, 0, true
+	cfg := &certMgr.AppConfig{}
+	cfg.Backend.MaxDuration = 90
+	cfg.Backend.Subject = certMgr.SubjectConfig{Organization: "DST Systems, Inc"}
+	cfg.Backend.CRLNumberFilename = "/var/lib/certMgr/crlnumber"
+	cfg.Backend.CAs = []certMgr.CAConfig{
+		{Name: "corp", SigningCACertificate: corpCert, Bundle: corpCert, SigningCAKeyFilename: corpKeyFile},
+		{Name: "lab", SigningCACertificate: labCert, Bundle: labCert, SigningCAKeyFilename: labKeyFile, MaxDuration: &week,
+			ClampDuration: &enabled, ApexWildcards: &enabled,
+			Subject: &certMgr.SubjectConfig{Organization: "DST Labs", OrganizationalUnit: []string{"Research"}}},
+		{Name: "personal", SigningCACertificate: personalCert, Bundle: personalCert, SigningCAKeyFilename: personalKeyFile,
+			MaxDuration: &unlimited},
+	}
+
+	cas, configs, err := NewCertificateAuthoritiesFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cas) != 3 || cas[0].Name != "corp" || cas[1].Name != "lab" || cas[2].Name != "personal" {
+		t.Fatalf("unexpected CAs %v", cas)
+	}
+	if cas[0].MaxDuration != 90*24*time.Hour || cas[1].MaxDuration != 7*24*time.Hour || cas[2].MaxDuration != 0 {
+		t.Errorf("unexpected maximum durations %s, %s & %s", cas[0].MaxDuration, cas[1].MaxDuration, cas[2].MaxDuration)
+	}
+	if cas[0].ClampDuration || cas[0].ApexWildcards || !cas[1].ClampDuration || !cas[1].ApexWildcards {
+		t.Error("expected only lab to clamp durations & permit apex wildcards")
+	}
+	if corp, _ := cas[0].Subject.subject("api.dstcorp.io", pkix.Name{}); corp.Organization[0] != "DST Systems, Inc" {
+		t.Errorf("expected corp to inherit the subject, got %s", corp)
+	}
+	if lab, _ := cas[1].Subject.subject("api.example.com", pkix.Name{}); lab.Organization[0] != "DST Labs" ||
+		len(lab.OrganizationalUnit) != 1 || lab.OrganizationalUnit[0] != "Research" {
+		t.Errorf("expected lab's own subject, got %s", lab)
+	}
+	if configs[1].Backend.CRLNumberFilename != "/var/lib/certMgr/crlnumber.lab" || len(configs[1].Backend.CAs) != 0 {
+		t.Errorf("unexpected configuration for lab: %+v", configs[1].Backend)
+	}
+
+	for _, test := range []struct {
+		cas     []certMgr.CAConfig
+		failure string
+	}{
+		{[]certMgr.CAConfig{{Name: "corp ca"}}, "not a valid CA name"},
+		{[]certMgr.CAConfig{cfg.Backend.CAs[0], cfg.Backend.CAs[0]}, "declared more than once"},
+		{[]certMgr.CAConfig{cfg.Backend.CAs[0], {Name: "copy", SigningCACertificate: corpCert, Bundle: corpCert,
+			SigningCAKeyFilename: corpKeyFile}}, "the same signing certificate"},
+	} {
+		cfg.Backend.CAs = test.cas
+		if _, _, err = NewCertificateAuthoritiesFromConfig(cfg); err == nil || !strings.Contains(err.Error(), test.failure) {
+			t.Errorf("expected %q, got %v", test.failure, err)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package backend
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/mchudgins/certMgr/pkg/certMgr"
+	"golang.org/x/net/context"
+)
+
+// the kinds of CertificateStore
+const (
+	StoreTypeBolt   = "bolt"
+	StoreTypeMemory = "memory"
+)
+
+var (
+	// ErrCertificateNotFound is returned when the serial number is not in the store
+	ErrCertificateNotFound = errors.New("certificate not found")
+	// ErrCertificateExists is returned when inserting a duplicate serial number
+	ErrCertificateExists = errors.New("certificate already exists")
+)
+
+// CertificateRecord is the inventory entry of an issued certificate
+type CertificateRecord struct {
+	Serial     string    `json:"serial"` // lower case hex
+	CommonName string    `json:"commonName"`
+	SANs       []string  `json:"sans"`
+	Requester  string    `json:"requester"`
+	CA         string    `json:"ca"`
+	Profile    string    `json:"profile"`
+	NotBefore  time.Time `json:"notBefore"`
+	NotAfter   time.Time `json:"notAfter"`
+	PEM        string    `json:"pem"`
+
+	Revoked          bool      `json:"revoked"`
+	RevokedAt        time.Time `json:"revokedAt,omitempty"`
+	RevocationReason int       `json:"revocationReason,omitempty"` // RFC 5280 CRLReason
+
+	Renews    string `json:"renews,omitempty"`    // serial of the certificate this one renewed
+	RenewedBy string `json:"renewedBy,omitempty"` // serial of the certificate which renewed this one
+
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// the revocation status selected by a CertificateFilter
+const (
+	RevocationStatusAny = iota
+	RevocationStatusValid
+	RevocationStatusRevoked
+)
+
+// CertificateFilter selects records from the certificate store.
+// Empty fields match every record.
+type CertificateFilter struct {
+	SAN              string // exact match of a subject alternate name
+	SANSuffix        string // name constraint style match (.dstcorp.io matches subdomains only)
+	Requester        string
+	CA               string
+	ExpiringBefore   time.Time // certificates whose NotAfter precedes this time
+	RevocationStatus int       // RevocationStatusAny, RevocationStatusValid or RevocationStatusRevoked
+	Labels           map[string]string
+}
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// CertificateStore persists the inventory of issued certificates
+type CertificateStore interface {
+	// Insert adds a newly issued certificate to the inventory
+	Insert(ctx context.Context, rec *CertificateRecord) error
+	// Get retrieves a certificate by serial number
+	Get(ctx context.Context, serial string) (*CertificateRecord, error)
+	// List returns up to pageSize records matching the filter, in serial number order,
+	// following the pageToken. The returned token retrieves the next page
+	// and is empty after the last page.
+	List(ctx context.Context, filter *CertificateFilter, pageToken string, pageSize int) ([]*CertificateRecord, string, error)
+	// Update atomically applies the update function to the record, storing the result
+	// unless the function returns an error. The updated record is returned.
+	Update(ctx context.Context, serial string, update func(rec *CertificateRecord) error) (*CertificateRecord, error)
+	// Close releases any resources held by the store
+	Close() error
+}
+
+// NewCertificateStore opens the certificate store described by the configuration
+func NewCertificateStore(cfg *certMgr.AppConfig) (CertificateStore, error) {
+	switch strings.ToLower(cfg.Backend.StoreType) {
+	case StoreTypeBolt:
+		return NewBoltStore(cfg.Backend.StoreFilename)
+	case StoreTypeMemory, "":
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown certificate store type %s", cfg.Backend.StoreType)
+	}
+}
+
+// SerialString formats a certificate serial number as used by the store
+func SerialString(serial *big.Int) string {
+	return serial.Text(16)
+}
+
+// normalizeSerial folds the case of a hex serial number and strips
+// the colons & leading zeroes often used when displaying them
+func normalizeSerial(serial string) string {
+	serial = strings.ToLower(strings.Replace(serial, ":", "", -1))
+	serial = strings.TrimLeft(serial, "0")
+	if len(serial) == 0 {
+		return "0"
+	}
+	return serial
+}
+
+// newCertificateRecord creates the inventory entry for a PEM encoded certificate
+func newCertificateRecord(certPEM string, caName string, profileName string, requester string) (*CertificateRecord, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return nil, errors.New("Unable to decode the issued certificate")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &CertificateRecord{
+		Serial:     SerialString(cert.SerialNumber),
+		CommonName: cert.Subject.CommonName,
+		Requester:  requester,
+		CA:         caName,
+		Profile:    profileName,
+		NotBefore:  cert.NotBefore,
+		NotAfter:   cert.NotAfter,
+		PEM:        certPEM,
+	}
+
+	rec.SANs = append(rec.SANs, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		rec.SANs = append(rec.SANs, ip.String())
+	}
+	rec.SANs = append(rec.SANs, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		rec.SANs = append(rec.SANs, u.String())
+	}
+
+	return rec, nil
+}
+
+// copyRecord returns a deep copy so callers cannot modify a stored record
+func copyRecord(rec *CertificateRecord) *CertificateRecord {
+	c := *rec
+	c.SANs = append([]string(nil), rec.SANs...)
+	if rec.Labels != nil {
+		c.Labels = make(map[string]string, len(rec.Labels))
+		for k, v := range rec.Labels {
+			c.Labels[k] = v
+		}
+	}
+	return &c
+}
+
+// matches returns true if the record satisfies every field of the filter
+func (f *CertificateFilter) matches(rec *CertificateRecord) bool {
+	if f == nil {
+		return true
+	}
+
+	if len(f.SAN) > 0 && !rec.hasSAN(func(san string) bool { return strings.EqualFold(san, f.SAN) }) {
+		return false
+	}
+
+	if len(f.SANSuffix) > 0 && !rec.hasSAN(func(san string) bool { return matchNameConstraint(san, f.SANSuffix) }) {
+		return false
+	}
+
+	if len(f.Requester) > 0 && !strings.EqualFold(rec.Requester, f.Requester) {
+		return false
+	}
+
+	if len(f.CA) > 0 && rec.CA != f.CA {
+		return false
+	}
+
+	if !f.ExpiringBefore.IsZero() && !rec.NotAfter.Before(f.ExpiringBefore) {
+		return false
+	}
+
+	switch f.RevocationStatus {
+	case RevocationStatusValid:
+		if rec.Revoked {
+			return false
+		}
+	case RevocationStatusRevoked:
+		if !rec.Revoked {
+			return false
+		}
+	}
+
+	for k, v := range f.Labels {
+		if value, ok := rec.Labels[k]; !ok || value != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (rec *CertificateRecord) hasSAN(match func(san string) bool) bool {
+	if match(rec.CommonName) {
+		return true
+	}
+	for _, san := range rec.SANs {
+		if match(san) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizePageSize applies the default & maximum page sizes
+func normalizePageSize(pageSize int) int {
+	if pageSize <= 0 {
+		return defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		return maxPageSize
+	}
+	return pageSize
+}
@@ -0,0 +1,125 @@
+package backend
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func testStore(t *testing.T, store CertificateStore) {
+	ctx := context.Background()
+
+	rec := &CertificateRecord{
+		Serial:     "0A:1b:2C",
+		CommonName: "foo.dstcorp.io",
+		SANs:       []string{"foo.dstcorp.io", "bar.dstcorp.io"},
+		Requester:  "bob",
+		CA:         "default",
+		Profile:    "server",
+		NotBefore:  time.Now().Truncate(time.Second),
+		NotAfter:   time.Now().Add(24 * time.Hour).Truncate(time.Second),
+		PEM:        "-----BEGIN CERTIFICATE-----",
+	}
+
+	if err := store.Insert(ctx, rec); err != nil {
+		t.Fatalf("Insert: %s", err)
+	}
+	if err := store.Insert(ctx, rec); err != ErrCertificateExists {
+		t.Errorf("duplicate Insert: expected ErrCertificateExists, got %v", err)
+	}
+
+	got, err := store.Get(ctx, "a1b2c")
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	if got.CommonName != rec.CommonName || got.Requester != rec.Requester ||
+		len(got.SANs) != 2 || !got.NotAfter.Equal(rec.NotAfter) {
+		t.Errorf("Get returned %+v, expected %+v", got, rec)
+	}
+
+	if _, err := store.Get(ctx, "ffff"); err != ErrCertificateNotFound {
+		t.Errorf("Get of unknown serial: expected ErrCertificateNotFound, got %v", err)
+	}
+
+	testList(t, store)
+}
+
+func testList(t *testing.T, store CertificateStore) {
+	ctx := context.Background()
+
+	for i, name := range []string{"a.fsg.dstcorp.io", "b.fsg.dstcorp.io", "c.cap.dstcorp.io", "fsg.dstcorp.io"} {
+		rec := &CertificateRecord{
+			Serial:     fmt.Sprintf("b%d", i),
+			CommonName: name,
+			SANs:       []string{name},
+			Requester:  "alice",
+			NotAfter:   time.Now().Add(time.Duration(i) * time.Hour),
+			Labels:     map[string]string{"team": fmt.Sprintf("t%d", i%2)},
+		}
+		if err := store.Insert(ctx, rec); err != nil {
+			t.Fatalf("Insert: %s", err)
+		}
+	}
+
+	tests := []struct {
+		filter   CertificateFilter
+		expected int
+	}{
+		{CertificateFilter{}, 5},
+		{CertificateFilter{Requester: "alice"}, 4},
+		{CertificateFilter{SAN: "c.cap.dstcorp.io"}, 1},
+		{CertificateFilter{SANSuffix: "fsg.dstcorp.io"}, 3},
+		{CertificateFilter{SANSuffix: ".fsg.dstcorp.io"}, 2},
+		{CertificateFilter{Labels: map[string]string{"team": "t1"}}, 2},
+		{CertificateFilter{ExpiringBefore: time.Now().Add(90 * time.Minute), Requester: "alice"}, 2},
+		{CertificateFilter{RevocationStatus: RevocationStatusRevoked}, 0},
+	}
+
+	for _, test := range tests {
+		var found int
+		var pageToken string
+		for {
+			page, next, err := store.List(ctx, &test.filter, pageToken, 2)
+			if err != nil {
+				t.Fatalf("List: %s", err)
+			}
+			if len(page) > 2 {
+				t.Errorf("List returned %d records for a page size of 2", len(page))
+			}
+			found += len(page)
+			if len(next) == 0 {
+				break
+			}
+			pageToken = next
+		}
+
+		if found != test.expected {
+			t.Errorf("List(%+v) found %d certificates, expected %d", test.filter, found, test.expected)
+		}
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestBoltStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certMgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewBoltStore(filepath.Join(dir, "certs.db"))
+	if err != nil {
+		t.Fatalf("NewBoltStore: %s", err)
+	}
+	defer store.Close()
+
+	testStore(t, store)
+}
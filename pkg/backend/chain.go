@@ -0,0 +1,121 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// buildChain orders the CA bundle from the signing certificate up to the root,
+// returning the intermediates (the signing certificate first, unless it is itself
+// the root) & the root. The bundle may hold the certificates in any order & may
+// include the signing certificate; the root must be in the bundle unless the
+// signing certificate is self-signed. The chain is then verified, so an expired
+// or misconfigured CA is refused at startup rather than issuing unverifiable
+// certificates.
+func buildChain(signing *x509.Certificate, bundle string) ([]*x509.Certificate, *x509.Certificate, error) {
+	certs, err := parseCertificates(bundle)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to parse the CA bundle: %s", err)
+	}
+
+	var chain []*x509.Certificate
+	used := map[*x509.Certificate]bool{}
+	cert := signing
+	for !isSelfSigned(cert) {
+		chain = append(chain, cert)
+
+		var issuer *x509.Certificate
+		for _, candidate := range certs {
+			if !used[candidate] && bytes.Equal(cert.RawIssuer, candidate.RawSubject) &&
+				cert.CheckSignatureFrom(candidate) == nil {
+				issuer = candidate
+				break
+			}
+		}
+		if issuer == nil {
+			return nil, nil, fmt.Errorf("the CA bundle does not chain %q to a root: the issuer %q is missing",
+				signing.Subject.CommonName, cert.Issuer.CommonName)
+		}
+
+		used[issuer] = true
+		cert = issuer
+	}
+	root := cert
+
+	for _, c := range certs {
+		if !inChain(c, append([]*x509.Certificate{signing, root}, chain...)) {
+			log.WithField("subject", c.Subject.CommonName).Warn("the CA bundle holds a certificate outside the signing CA's chain")
+		}
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	intermediates := x509.NewCertPool()
+	for _, c := range chain {
+		intermediates.AddCert(c)
+	}
+	_, err = signing.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("the CA bundle does not chain %q to %q: %s",
+			signing.Subject.CommonName, root.Subject.CommonName, err)
+	}
+
+	return chain, root, nil
+}
+
+// completeBundle appends the root to a bundle which holds intermediates but no root,
+// as bundles made before the root was required do
+func completeBundle(bundle string, root string) string {
+	certs, err := parseCertificates(bundle)
+	if err != nil || len(certs) == 0 || len(root) == 0 {
+		return bundle
+	}
+	for _, cert := range certs {
+		if isSelfSigned(cert) {
+			return bundle
+		}
+	}
+
+	return strings.TrimRight(bundle, "\n") + "\n" + root
+}
+
+// inChain reports whether the certificate (or a copy of it) is in the chain
+func inChain(cert *x509.Certificate, chain []*x509.Certificate) bool {
+	for _, c := range chain {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSelfSigned reports whether the certificate is a root
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
+
+// encodeChain returns the PEM encoded certificates
+func encodeChain(chain []*x509.Certificate) string {
+	var b bytes.Buffer
+	for _, cert := range chain {
+		pem.Encode(&b, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return b.String()
+}
+
+// rootPEM returns the PEM encoded root certificate
+func (c *ca) rootPEM() string {
+	if len(c.RootCertificate.Raw) == 0 {
+		return ""
+	}
+	return encodeChain([]*x509.Certificate{&c.RootCertificate})
+}
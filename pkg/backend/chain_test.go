@@ -0,0 +1,151 @@
+package backend
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mchudgins/certMgr/pkg/certMgr"
+	pb "github.com/mchudgins/certMgr/pkg/service"
+	"golang.org/x/net/context"
+)
+
+func TestBuildChain(t *testing.T) {
+	valid := time.Now().Add(24 * time.Hour)
+	root := newTestCACertificate(t, "root", nil, nil, valid)
+	intermediate := newTestCACertificate(t, "intermediate", nil, root, valid)
+	signing := newTestCACertificate(t, "signing", nil, intermediate, valid)
+	expired := newTestCACertificate(t, "expired", nil, root, time.Now().Add(-time.Hour))
+	signedByExpired := newTestCACertificate(t, "signing", nil, expired, valid)
+	other := newTestCACertificate(t, "other", nil, nil, valid)
+
+	tests := []struct {
+		name    string
+		signing *testCACertificate
+		bundle  string
+		chain   []*testCACertificate
+		failure string
+	}{
+		{"ordered", signing, signing.pem() + intermediate.pem() + root.pem(), []*testCACertificate{signing, intermediate}, ""},
+		{"unordered", signing, root.pem() + intermediate.pem(), []*testCACertificate{signing, intermediate}, ""},
+		{"unrelated certificate", signing, other.pem() + intermediate.pem() + root.pem(), []*testCACertificate{signing, intermediate}, ""},
+		{"self-signed", root, "", nil, ""},
+		{"missing root", signing, signing.pem() + intermediate.pem(), nil, `the issuer "root" is missing`},
+		{"missing intermediate", signing, root.pem(), nil, `the issuer "intermediate" is missing`},
+		{"wrong root", signing, intermediate.pem() + other.pem(), nil, `the issuer "root" is missing`},
+		{"expired intermediate", signedByExpired, expired.pem() + root.pem(), nil, "expired"},
+		{"not a certificate", signing, "-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n", nil, "unable to parse"},
+	}
+
+	for _, test := range tests {
+		chain, r, err := buildChain(test.signing.cert, test.bundle)
+		if len(test.failure) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.failure) {
+				t.Errorf("%s: expected %q, got %v", test.name, test.failure, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		if !r.Equal(root.cert) {
+			t.Errorf("%s: expected the root, got %s", test.name, r.Subject.CommonName)
+		}
+		if len(chain) != len(test.chain) {
+			t.Errorf("%s: expected %d intermediates, got %d", test.name, len(test.chain), len(chain))
+			continue
+		}
+		for i := range chain {
+			if !chain[i].Equal(test.chain[i].cert) {
+				t.Errorf("%s: expected %s at %d, got %s", test.name, test.chain[i].cert.Subject.CommonName, i, chain[i].Subject.CommonName)
+			}
+		}
+	}
+}
+
+func TestCreateReplyChain(t *testing.T) {
+	valid := time.Now().Add(24 * time.Hour)
+	root := newTestCACertificate(t, "root", nil, nil, valid)
+	intermediate := newTestCACertificate(t, "intermediate", nil, root, valid)
+	signing := newTestCACertificate(t, "signing", nil, intermediate, valid)
+
+	c := signing.ca(t, "test", root.pem()+intermediate.pem())
+	s := &server{ca: c}
+	reply, err := s.CreateCertificate(context.Background(), &pb.CreateRequest{Name: "foo.dstcorp.io", Duration: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reply.GetChain() != signing.pem()+intermediate.pem() {
+		t.Error("expected the chain to be the signing & intermediate CAs")
+	}
+	if reply.GetRoot() != root.pem() {
+		t.Error("expected the root CA")
+	}
+
+	leaf := parseTestCertificate(t, reply.GetCertificate())
+	intermediates := x509.NewCertPool()
+	intermediates.AppendCertsFromPEM([]byte(reply.GetChain()))
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM([]byte(reply.GetRoot()))
+	if _, err = leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates}); err != nil {
+		t.Errorf("the certificate does not verify: %s", err)
+	}
+
+	// the CA refuses to start with a broken chain
+	_, err = createCA("test", []byte(signing.pem()), []byte(signing.keyPEM(t)), intermediate.pem(), nil)
+	if err == nil || !strings.Contains(err.Error(), "does not chain") {
+		t.Errorf("expected the incomplete chain to be refused, got %v", err)
+	}
+}
+
+func TestCompleteBundle(t *testing.T) {
+	valid := time.Now().Add(24 * time.Hour)
+	root := newTestCACertificate(t, "root", nil, nil, valid)
+	intermediate := newTestCACertificate(t, "intermediate", nil, root, valid)
+	signing := newTestCACertificate(t, "signing", nil, intermediate, valid)
+
+	// a bundle made before the root was required
+	legacy := signing.pem() + intermediate.pem()
+	for bundle, expected := range map[string]string{
+		legacy:                              legacy + root.pem(),
+		legacy + root.pem():                 legacy + root.pem(),
+		"":                                  "",
+		intermediate.pem() + "\n\n":         intermediate.pem() + root.pem(),
+		"-----BEGIN CERTIFICATE-----\nAAAA": "-----BEGIN CERTIFICATE-----\nAAAA",
+	} {
+		if completed := completeBundle(bundle, root.pem()); completed != expected {
+			t.Errorf("expected %q, got %q", expected, completed)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "certMgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "signing.key")
+	if err = ioutil.WriteFile(keyFile, []byte(signing.keyPEM(t)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the configured root completes the bundle
+	cfg := &certMgr.AppConfig{}
+	cfg.Backend.SigningCACertificate = signing.pem()
+	cfg.Backend.Bundle = legacy
+	cfg.Backend.RootCACertificate = root.pem()
+	cfg.Backend.SigningCAKeyFilename = keyFile
+	c, err := NewCertificateAuthorityFromConfig("test", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.RootCertificate.Equal(root.cert) || c.Bundle != legacy {
+		t.Errorf("expected the chain to end at the configured root, got %s", c.RootCertificate.Subject.CommonName)
+	}
+}
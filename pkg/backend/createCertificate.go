@@ -4,16 +4,15 @@ import (
 	"bytes"
 	"crypto"
 	"crypto/ecdsa"
-	"crypto/elliptic"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
-	"errors"
-	"fmt"
 	"math/big"
 	"net"
+	"net/url"
 	"os"
 	"strings"
 	"time"
@@ -21,7 +20,9 @@ import (
 	log "github.com/sirupsen/logrus"
 	pb "github.com/mchudgins/certMgr/pkg/service"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 type ca struct {
@@ -29,7 +30,23 @@ type ca struct {
 	SigningCertificate x509.Certificate
 	SigningKey         crypto.Signer
 	RootCertificate    x509.Certificate
-	Bundle             string
+	Bundle             string   // the PEM encoded intermediates, from the signing CA up to the root
+	KeyTypes           []string // key types this CA will generate (an empty list permits all)
+	Profiles           map[string]*profile
+	AllowedProfiles    []string // profiles requesters may select (an empty list permits the default allowlist)
+	DefaultProfile     string
+	Subject            *subjectPolicy
+	Store              CertificateStore // inventory of issued certificates (may be nil)
+	CRL                *crlPublisher
+	OCSP               *ocspResponder
+	CT                 *transparencyLog
+	IssuerURLs         []string      // authority information access caIssuers
+	OCSPURLs           []string      // authority information access OCSP
+	CRLURLs            []string      // CRL distribution points
+	MaxDuration        time.Duration // longest lifetime of an issued certificate (0 is unlimited)
+	Policy             *domainPolicy // per-user & per-group entitlements (nil permits everyone)
+	ClampDuration      bool          // shorten, rather than refuse, longer requests
+	ApexWildcards      bool          // permit wildcards directly under the permitted domains
 }
 
 // CreateCertificate creates an x509 certificate
@@ -40,29 +57,98 @@ func (s *server) CreateCertificate(ctx context.Context, in *pb.CreateRequest) (*
 		log.Debugf("md[ %s ] : %s", key, value[0])
 	}
 
+	if err := checkFormat(in.GetFormat(), in.GetPassword()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	var validFor time.Duration
 	validFor = time.Duration(in.GetDuration()) * time.Hour * 24
 
-	cert, key, err := s.ca.CreateCertificate(ctx, in.GetName(), in.GetAlternateNames(), validFor)
-	return &pb.CreateReply{Certificate: cert, Key: key}, err
+	var subject pkix.Name
+	if sub := in.GetSubject(); sub != nil {
+		subject = pkix.Name{
+			OrganizationalUnit: sub.GetOrganizationalUnit(),
+		}
+		if len(sub.GetOrganization()) > 0 {
+			subject.Organization = []string{sub.GetOrganization()}
+		}
+		if len(sub.GetCountry()) > 0 {
+			subject.Country = []string{sub.GetCountry()}
+		}
+		if len(sub.GetLocality()) > 0 {
+			subject.Locality = []string{sub.GetLocality()}
+		}
+		if len(sub.GetProvince()) > 0 {
+			subject.Province = []string{sub.GetProvince()}
+		}
+	}
+
+	c, err := s.selectCA(ctx, in.GetCa(), uniqueHosts(in.GetName(), in.GetAlternateNames(), nil, nil, nil))
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	cert, key, err := c.CreateCertificate(ctx, in.GetName(), in.GetAlternateNames(), validFor,
+		in.GetKeyType(), in.GetProfile(), subject, in.GetLabels())
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	reply := &pb.CreateReply{Certificate: cert, Key: key, Chain: c.Bundle, Root: c.rootPEM(), Ca: c.Name}
+	if normalizeFormat(in.GetFormat()) != FormatPEM {
+		reply.Content, err = EncodeCredentials(in.GetFormat(), cert, key, c.Bundle, in.GetPassword(), in.GetName())
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	return reply, nil
 }
 
 func (c ca) CreateCertificate(ctx context.Context,
 	commonName string,
 	alternateNames []string,
-	duration time.Duration) (cert string, key string, err error) {
-	requestedHosts := make([]string, 1, len(alternateNames)+1)
-	requestedHosts[0] = commonName
-	copy(requestedHosts[1:], alternateNames)
+	duration time.Duration,
+	keyType string,
+	profileName string,
+	requestedSubject pkix.Name,
+	labels map[string]string) (cert string, key string, err error) {
+	requestedHosts := uniqueHosts(commonName, alternateNames, nil, nil, nil)
+
+	prof, err := c.lookupProfile(profileName)
+	if err != nil {
+		return "", "", err
+	}
 
-	hosts, err := c.validateRequest(alternateNames, duration)
+	hosts, err := c.validateRequest(ctx, requestedHosts, duration, prof)
 	if err != nil {
 		return "", "", err
 	}
 
+	if err = prof.checkRequest(hosts); err != nil {
+		return "", "", err
+	}
+
+	duration, err = c.checkDuration(duration, prof)
+	if err != nil {
+		return "", "", err
+	}
+
+	subject, err := c.Subject.subject(hosts[0], requestedSubject)
+	if err != nil {
+		return "", "", err
+	}
+
+	if !c.permitsKeyType(keyType) {
+		return "", "", policyErrorf("key type %s is not permitted by this CA", keyType)
+	}
+
 	// create the CSR
 
-	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	priv, err := generateKey(keyType)
+	if err != nil {
+		return "", "", err
+	}
 	/*
 		keyOut, err := os.Create("key.pem")
 		defer keyOut.Close()
@@ -72,74 +158,123 @@ func (c ca) CreateCertificate(ctx context.Context,
 		pem.Encode(keyOut, pemBlockForKey(priv))
 	*/
 
+	template := newTemplate(subject, hosts, duration, prof)
+
+	// sign the CSR
+	cert, err = c.sign(ctx, template, publicKey(priv), prof, labels)
+	if err != nil {
+		return "", "", err
+	}
+
+	// prepare the response
+	var keyBuffer bytes.Buffer
+
+	pem.Encode(&keyBuffer, pemBlockForKey(priv))
+	key = keyBuffer.String()
+
+	return cert, key, err
+}
+
+// newTemplate creates the certificate template for the (already validated) subject & hosts
+func newTemplate(subject pkix.Name, hosts []string, duration time.Duration, prof *profile) *x509.Certificate {
 	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
 	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		log.WithError(err).Error("Unable to generate a serial number")
+	}
+
 	notBefore := time.Now()
 	notAfter := notBefore.Add(duration)
-	template := x509.Certificate{
+	template := &x509.Certificate{
 		SerialNumber: serialNumber,
-		Subject: pkix.Name{
-			CommonName:   hosts[0],
-			Organization: []string{"DST Systems, Inc"},
-		},
-		NotBefore: notBefore,
-		NotAfter:  notAfter,
-
-		KeyUsage: x509.KeyUsageKeyEncipherment |
-			x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
-		BasicConstraintsValid: true,
+		Subject:      subject,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
 	}
 
+	prof.apply(template)
+
 	for _, h := range hosts {
-		if ip := net.ParseIP(h); ip != nil {
-			template.IPAddresses = append(template.IPAddresses, ip)
-		} else {
+		switch sanType(h) {
+		case sanIP:
+			template.IPAddresses = append(template.IPAddresses, net.ParseIP(h))
+		case sanEmail:
+			template.EmailAddresses = append(template.EmailAddresses, h)
+		case sanURI:
+			if u, err := url.Parse(h); err == nil {
+				template.URIs = append(template.URIs, u)
+			}
+		default:
 			template.DNSNames = append(template.DNSNames, h)
 		}
 	}
 
-	// sign the CSR
-	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &c.SigningCertificate, publicKey(priv), c.SigningKey)
+	return template
+}
+
+// sanType classifies a requested name as a DNS name, IP address, email address or URI
+func sanType(name string) string {
+	if ip := net.ParseIP(name); ip != nil {
+		return sanIP
+	}
+	if strings.Contains(name, "://") {
+		return sanURI
+	}
+	if strings.Contains(name, "@") {
+		return sanEmail
+	}
+	return sanDNS
+}
+
+// sign issues the certificate described by template for the public key,
+// records it in the CA's inventory and returns the PEM encoded certificate
+func (c ca) sign(ctx context.Context,
+	template *x509.Certificate,
+	pub interface{},
+	prof *profile,
+	labels map[string]string) (string, error) {
+	c.clampNotAfter(template)
+
+	if err := c.addIssuerInfo(template, pub); err != nil {
+		log.WithError(err).Error("Unable to compute the certificate's key identifiers")
+		return "", err
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, &c.SigningCertificate, pub, c.SigningKey)
 	if err != nil {
 		log.WithError(err).Error("Unable to CreateCertificate")
-		return "", "", err
+		return "", err
 	}
 
-	// prepare the response
-	var certBuffer, keyBuffer bytes.Buffer
+	var certBuffer bytes.Buffer
+	pem.Encode(&certBuffer, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+	cert := certBuffer.String()
+
+	// publish the certificate in the transparency log before recording it, so the
+	// inventory never holds a certificate the log is missing
+	if c.CT != nil {
+		if err := c.CT.append(derBytes); err != nil {
+			log.WithError(err).WithField("serial", SerialString(template.SerialNumber)).
+				Error("Unable to record the certificate in the transparency log")
+			return "", err
+		}
+	}
 
-	/*
-		certOut, err := os.Create("cert.pem")
-		defer certOut.Close()
+	// persist the certificate
+	if c.Store != nil {
+		rec, err := newCertificateRecord(cert, c.Name, prof.Name, requester(ctx))
+		if err == nil {
+			rec.Labels = labels
+			err = c.Store.Insert(ctx, rec)
+		}
 		if err != nil {
-			log.WithError(err).Fatal("failed to open cert.pem for writing")
+			log.WithError(err).WithField("serial", SerialString(template.SerialNumber)).
+				Error("Unable to record the certificate in the inventory")
+			return "", err
 		}
+	}
 
-		pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
-	*/
-
-	pem.Encode(&certBuffer, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
-	cert = certBuffer.String()
-	pem.Encode(&keyBuffer, pemBlockForKey(priv))
-	key = keyBuffer.String()
-
-	//	// persist the certificate
-	//	serverCert, err := x509.ParseCertificate(derBytes)
-	//	persistedCert := newCertFromCertificate(serverCert)
-	//
-	//	/* using hystrix/circuitbreaker to persist the data */
-	//	err = hystrix.Do("certs-mysql", func() error {
-	//		persistedCert.Insert()
-	//		return nil
-	//	}, nil)
-
-	/*
-		data := &CertificateData{*serverCert}
-		data.Persist(ctx)
-	*/
-
-	return cert, key, err
+	return cert, nil
 }
 
 // from golang.org/pkg/crypto/x509/verify.go
@@ -168,48 +303,26 @@ func matchNameConstraint(domain, constraint string) bool {
 	return isSubdomain != constraintHasLeadingDot
 }
 
-func (c *ca) validateRequest(requestedHosts []string, validFor time.Duration) ([]string, error) {
-	var hosts = make([]string, len(requestedHosts))
-
-	supportedDomain := false
-	fIPAddr := false
-
-	for i, s := range requestedHosts {
-		if ip := net.ParseIP(s); ip != nil {
-			fIPAddr = true
-			if i == 0 {
-				return nil, errors.New("Subject name of Certificate must NOT be an IP address")
-			}
-			hosts[i] = s
-		}
-
-		if !fIPAddr {
-			h := strings.ToLower(s)
-			hosts[i] = h
-
-			if strings.HasPrefix(h, "www.") {
-				return nil, errors.New("www. host names are not supported")
-			}
-
-			if strings.HasPrefix(h, ".") {
-				return nil, errors.New(". host names are not supported")
-			}
-
-			if len(c.SigningCertificate.PermittedDNSDomains) > 0 {
-				for _, constraint := range c.SigningCertificate.PermittedDNSDomains {
-					if matchNameConstraint(h, constraint) {
-						supportedDomain = true
-					}
-				}
-			} else {
-				supportedDomain = true
-			}
-
-			if !supportedDomain {
-				return nil, fmt.Errorf("%s is not a permitted domain", h)
-			}
+// validateRequest checks every requested name (see validateNames), returning the
+// names to place in the certificate or a refusal listing each refused name
+func (c *ca) validateRequest(ctx context.Context,
+	requestedHosts []string,
+	validFor time.Duration,
+	prof *profile) ([]string, error) {
+	if len(requestedHosts) == 0 {
+		return nil, policyErrorf("a subject name is required")
+	}
 
+	hosts := make([]string, len(requestedHosts))
+	var refusals []string
+	for i, result := range c.validateNames(ctx, requestedHosts, prof.Name) {
+		if result.err != nil {
+			refusals = append(refusals, result.err.Error())
 		}
+		hosts[i] = result.value
+	}
+	if len(refusals) > 0 {
+		return nil, policyErrorf("%s", strings.Join(refusals, "; "))
 	}
 
 	return hosts, nil
@@ -221,6 +334,8 @@ func publicKey(priv interface{}) interface{} {
 		return &k.PublicKey
 	case *ecdsa.PrivateKey:
 		return &k.PublicKey
+	case ed25519.PrivateKey:
+		return k.Public()
 	default:
 		return nil
 	}
@@ -237,6 +352,13 @@ func pemBlockForKey(priv interface{}) *pem.Block {
 			os.Exit(2)
 		}
 		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: b}
+	case ed25519.PrivateKey:
+		b, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			log.WithError(err).Fatal("Unable to marshall Ed25519 private key")
+			os.Exit(2)
+		}
+		return &pem.Block{Type: "PRIVATE KEY", Bytes: b}
 	default:
 		return nil
 	}
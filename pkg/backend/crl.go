@@ -0,0 +1,184 @@
+package backend
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// the RFC 5280 CRLReason codes
+const (
+	ReasonUnspecified          = 0
+	ReasonKeyCompromise        = 1
+	ReasonCACompromise         = 2
+	ReasonAffiliationChanged   = 3
+	ReasonSuperseded           = 4
+	ReasonCessationOfOperation = 5
+	ReasonCertificateHold      = 6
+	ReasonPrivilegeWithdrawn   = 9
+	ReasonAACompromise         = 10
+)
+
+// validRevocationReason returns true for the reason codes which may be
+// used to revoke a certificate. 7 is unused and removeFromCRL (8) only
+// appears in delta CRLs.
+func validRevocationReason(reason int) bool {
+	switch reason {
+	case ReasonUnspecified, ReasonKeyCompromise, ReasonCACompromise,
+		ReasonAffiliationChanged, ReasonSuperseded, ReasonCessationOfOperation,
+		ReasonCertificateHold, ReasonPrivilegeWithdrawn, ReasonAACompromise:
+		return true
+	}
+	return false
+}
+
+// crlPublisher generates & serves the CA's certificate revocation list
+type crlPublisher struct {
+	sync.RWMutex
+	ca         *ca
+	store      CertificateStore
+	numberFile string        // persists the CRL number, hex encoded (like OpenSSL's db/crlnumber)
+	validity   time.Duration // interval between thisUpdate & nextUpdate
+	number     *big.Int      // number of the next CRL
+	der        []byte        // the current CRL
+}
+
+func newCRLPublisher(c *ca, store CertificateStore, numberFile string, validity time.Duration) (*crlPublisher, error) {
+	p := &crlPublisher{
+		ca:         c,
+		store:      store,
+		numberFile: numberFile,
+		validity:   validity,
+		number:     big.NewInt(1),
+	}
+
+	data, err := ioutil.ReadFile(numberFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if _, ok := p.number.SetString(strings.TrimSpace(string(data)), 16); !ok {
+			return nil, fmt.Errorf("%s does not contain a hexadecimal CRL number", numberFile)
+		}
+	}
+
+	return p, nil
+}
+
+// path returns the stable URL path at which the CA's CRL is served
+func (p *crlPublisher) path() string {
+	return "/crl/" + p.ca.Name + ".crl"
+}
+
+// generate signs a new CRL listing every unexpired, revoked certificate of the CA
+func (p *crlPublisher) generate(ctx context.Context) error {
+	p.Lock()
+	defer p.Unlock()
+
+	now := time.Now()
+	filter := &CertificateFilter{CA: p.ca.Name, RevocationStatus: RevocationStatusRevoked}
+
+	var entries []x509.RevocationListEntry
+	var pageToken string
+	for {
+		records, next, err := p.store.List(ctx, filter, pageToken, maxPageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, rec := range records {
+			if rec.NotAfter.Before(now) {
+				continue
+			}
+
+			serial, ok := new(big.Int).SetString(rec.Serial, 16)
+			if !ok {
+				log.WithField("serial", rec.Serial).Warn("Unable to parse serial number of revoked certificate")
+				continue
+			}
+
+			entries = append(entries, x509.RevocationListEntry{
+				SerialNumber:   serial,
+				RevocationTime: rec.RevokedAt,
+				ReasonCode:     rec.RevocationReason,
+			})
+		}
+
+		if len(next) == 0 {
+			break
+		}
+		pageToken = next
+	}
+
+	template := &x509.RevocationList{
+		Number:                    new(big.Int).Set(p.number),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(p.validity),
+		RevokedCertificateEntries: entries,
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, template, &p.ca.SigningCertificate, p.ca.SigningKey)
+	if err != nil {
+		log.WithError(err).WithField("ca", p.ca.Name).Error("Unable to create the CRL")
+		return err
+	}
+
+	// bump the CRL number
+	p.number.Add(p.number, big.NewInt(1))
+	if len(p.numberFile) > 0 {
+		err = ioutil.WriteFile(p.numberFile, []byte(fmt.Sprintf("%X\n", p.number)), 0600)
+		if err != nil {
+			log.WithError(err).WithField("file", p.numberFile).Error("Unable to save the CRL number")
+			return err
+		}
+	}
+
+	p.der = der
+	log.WithFields(log.Fields{"ca": p.ca.Name, "crlNumber": template.Number, "revoked": len(entries)}).
+		Info("CRL generated")
+
+	return nil
+}
+
+// run regenerates the CRL on a schedule. Without an interval, the CRL is only
+// regenerated when a certificate is revoked.
+func (p *crlPublisher) run(interval time.Duration) {
+	if interval <= 0 {
+		log.WithField("ca", p.ca.Name).Warn("scheduled CRL generation is disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := p.generate(context.Background()); err != nil {
+			log.WithError(err).WithField("ca", p.ca.Name).Error("Scheduled CRL generation failed")
+		}
+	}
+}
+
+// ServeHTTP serves the DER encoded CRL
+func (p *crlPublisher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.RLock()
+	der := p.der
+	p.RUnlock()
+
+	if der == nil {
+		http.Error(w, "CRL not yet available", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	w.Write(der)
+}
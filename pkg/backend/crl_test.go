@@ -0,0 +1,95 @@
+package backend
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestRevocationList(t *testing.T) {
+	ctx := context.Background()
+	c := newTestCA(t)
+
+	dir, err := ioutil.TempDir("", "certMgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c.CRL, err = newCRLPublisher(c, c.Store, filepath.Join(dir, "crlnumber"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.CRL.generate(ctx); err != nil {
+		t.Fatalf("generate: %s", err)
+	}
+
+	cert, _, err := c.CreateCertificate(ctx, "foo.dstcorp.io", []string{"foo.dstcorp.io"},
+		24*time.Hour, "", "", pkix.Name{}, nil)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %s", err)
+	}
+	block, _ := pem.Decode([]byte(cert))
+	issued, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial := SerialString(issued.SerialNumber)
+	if _, err = c.Revoke(ctx, serial, ReasonKeyCompromise); err != nil {
+		t.Fatalf("Revoke: %s", err)
+	}
+	if _, err = c.Revoke(ctx, serial, ReasonKeyCompromise); err != errAlreadyRevoked {
+		t.Errorf("second Revoke: expected errAlreadyRevoked, got %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	c.CRL.ServeHTTP(w, httptest.NewRequest("GET", c.CRL.path(), nil))
+
+	crl, err := x509.ParseRevocationList(w.Body.Bytes())
+	if err != nil {
+		t.Fatalf("ParseRevocationList: %s", err)
+	}
+	if err = crl.CheckSignatureFrom(&c.SigningCertificate); err != nil {
+		t.Errorf("CRL signature: %s", err)
+	}
+	if crl.Number.Int64() != 2 {
+		t.Errorf("CRL number is %s, expected 2", crl.Number)
+	}
+	if len(crl.RevokedCertificateEntries) != 1 ||
+		crl.RevokedCertificateEntries[0].SerialNumber.Cmp(issued.SerialNumber) != 0 ||
+		crl.RevokedCertificateEntries[0].ReasonCode != ReasonKeyCompromise {
+		t.Errorf("CRL entries %+v do not list serial %s", crl.RevokedCertificateEntries, serial)
+	}
+
+	number, err := ioutil.ReadFile(filepath.Join(dir, "crlnumber"))
+	if err != nil || string(number) != "3\n" {
+		t.Errorf("crlnumber file contains %q (%v), expected 3", number, err)
+	}
+
+	// a revocation stands even when the CRL cannot be published
+	c.CRL.numberFile = filepath.Join(dir, "missing", "crlnumber")
+	cert, _, err = c.CreateCertificate(ctx, "bar.dstcorp.io", nil, 24*time.Hour, "", "", pkix.Name{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial = SerialString(parseTestCertificate(t, cert).SerialNumber)
+	if _, err = c.Revoke(ctx, serial, ReasonSuperseded); err != nil {
+		t.Errorf("expected the revocation to succeed, got %s", err)
+	}
+	if rec, err := c.Store.Get(ctx, serial); err != nil || !rec.Revoked {
+		t.Errorf("expected %s to be revoked (%v)", serial, err)
+	}
+
+	// without an interval, the CRL is only regenerated on revocation
+	c.CRL.run(0)
+	c.CRL.run(-time.Hour)
+}
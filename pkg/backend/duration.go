@@ -0,0 +1,93 @@
+package backend
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// policyError is returned when the CA's policy refuses a request
+type policyError struct {
+	msg string
+}
+
+func (e *policyError) Error() string {
+	return e.msg
+}
+
+func policyErrorf(format string, args ...interface{}) error {
+	return &policyError{msg: fmt.Sprintf(format, args...)}
+}
+
+// statusError converts policy refusals (however wrapped) to InvalidArgument, which the
+// gateway reports as HTTP 400, and signing key failures to Unavailable.
+// Other errors are returned unchanged.
+func statusError(err error) error {
+	var pe *policyError
+	if errors.As(err, &pe) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	var se *signerError
+	if errors.As(err, &se) {
+		return status.Error(codes.Unavailable, se.Error())
+	}
+	return err
+}
+
+// maxDuration returns the longest lifetime the CA issues for the profile.
+// Zero means the lifetime is unlimited (other than by the CA's own NotAfter).
+func (c *ca) maxDuration(prof *profile) time.Duration {
+	max := c.MaxDuration
+	if prof.MaxDuration > 0 && (max == 0 || prof.MaxDuration < max) {
+		max = prof.MaxDuration
+	}
+	return max
+}
+
+// checkDuration applies the maximum lifetime policy to the requested duration,
+// returning the duration to issue. A zero duration requests the maximum.
+// Longer durations are shortened to the maximum when the CA clamps them,
+// otherwise they are refused.
+func (c *ca) checkDuration(duration time.Duration, prof *profile) (time.Duration, error) {
+	max := c.maxDuration(prof)
+
+	switch {
+	case duration < 0:
+		return 0, policyErrorf("the duration must not be negative")
+
+	case duration == 0:
+		if max == 0 {
+			return 0, policyErrorf("a duration is required")
+		}
+		return max, nil
+
+	case max > 0 && duration > max:
+		if !c.ClampDuration {
+			limit := "this CA permits"
+			if prof.MaxDuration > 0 && max == prof.MaxDuration {
+				limit = fmt.Sprintf("the %s profile permits", prof.Name)
+			}
+			return 0, policyErrorf("%s a maximum duration of %d days", limit, max/(time.Hour*24))
+		}
+
+		log.WithFields(log.Fields{"requested": duration, "maximum": max, "profile": prof.Name}).
+			Info("requested duration shortened to the maximum")
+		return max, nil
+	}
+
+	return duration, nil
+}
+
+// clampNotAfter ensures the certificate does not outlive the signing CA
+func (c *ca) clampNotAfter(template *x509.Certificate) {
+	if template.NotAfter.After(c.SigningCertificate.NotAfter) {
+		log.WithFields(log.Fields{"requested": template.NotAfter, "caNotAfter": c.SigningCertificate.NotAfter}).
+			Info("certificate lifetime shortened to that of the signing CA")
+		template.NotAfter = c.SigningCertificate.NotAfter
+	}
+}
@@ -0,0 +1,111 @@
+package backend
+
+import (
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const day = 24 * time.Hour
+
+func TestCheckDuration(t *testing.T) {
+	c := newTestCA(t)
+	c.MaxDuration = 90 * day
+	server := c.Profiles["server"]
+	server.MaxDuration = 0
+	ocspSigning := c.Profiles["ocsp-signing"]
+	ocspSigning.MaxDuration = 30 * day
+
+	tests := []struct {
+		requested time.Duration
+		prof      *profile
+		clamp     bool
+		expected  time.Duration
+		refusal   string // substring of the expected refusal, empty if permitted
+	}{
+		{10 * day, server, false, 10 * day, ""},
+		{0, server, false, 90 * day, ""},
+		{90 * day, server, false, 90 * day, ""},
+		{91 * day, server, false, 0, "this CA permits a maximum duration of 90 days"},
+		{91 * day, server, true, 90 * day, ""},
+		{60 * day, ocspSigning, false, 0, "the ocsp-signing profile permits a maximum duration of 30 days"},
+		{60 * day, ocspSigning, true, 30 * day, ""},
+		{-day, server, true, 0, "must not be negative"},
+	}
+
+	for _, test := range tests {
+		c.ClampDuration = test.clamp
+		duration, err := c.checkDuration(test.requested, test.prof)
+		if len(test.refusal) > 0 {
+			if _, ok := err.(*policyError); !ok || !strings.Contains(err.Error(), test.refusal) {
+				t.Errorf("%s/%s: expected a policy error %q, got %v", test.prof.Name, test.requested, test.refusal, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s/%s: %s", test.prof.Name, test.requested, err)
+		} else if duration != test.expected {
+			t.Errorf("%s/%s: expected %s, got %s", test.prof.Name, test.requested, test.expected, duration)
+		}
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	c := newTestCA(t)
+	c.KeyTypes = []string{KeyTypeECDSAP256}
+	c.AllowedProfiles = []string{"server"}
+
+	_, profileErr := c.lookupProfile("client")
+	_, unknownErr := c.lookupProfile("nope")
+	server, _ := c.lookupProfile("server")
+	_, _, keyTypeErr := c.CreateCertificate(context.Background(), "foo.dstcorp.io", nil, day, KeyTypeRSA2048, "", pkix.Name{}, nil)
+	_, csrErr := parseCertificateRequest([]byte("not a CSR"))
+
+	for _, err := range []error{
+		profileErr,
+		unknownErr,
+		server.checkRequest([]string{"spiffe://svc.dstcorp.io/db"}),
+		keyTypeErr,
+		csrErr,
+		fmt.Errorf("renewal: %w", policyErrorf("refused")),
+	} {
+		if status.Code(statusError(err)) != codes.InvalidArgument {
+			t.Errorf("%v: expected InvalidArgument, got %v", err, statusError(err))
+		}
+	}
+
+	if status.Code(statusError(errors.New("disk full"))) == codes.InvalidArgument {
+		t.Error("expected other errors to be returned unchanged")
+	}
+}
+
+func TestDurationPolicy(t *testing.T) {
+	ctx := context.Background()
+	c := newTestCA(t)
+
+	// refusals are InvalidArgument
+	c.MaxDuration = 30 * day
+	_, _, err := c.CreateCertificate(ctx, "foo.dstcorp.io", []string{"foo.dstcorp.io"},
+		100*365*day, "", "server", pkix.Name{}, nil)
+	if status.Code(statusError(err)) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument, got %v", err)
+	}
+
+	// certificates never outlive the CA
+	c.MaxDuration = 0
+	certPEM, _, err := c.CreateCertificate(ctx, "foo.dstcorp.io", []string{"foo.dstcorp.io"},
+		100*365*day, "", "server", pkix.Name{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert := parseTestCertificate(t, certPEM); !cert.NotAfter.Equal(c.SigningCertificate.NotAfter) {
+		t.Errorf("expected NotAfter %s, got %s", c.SigningCertificate.NotAfter, cert.NotAfter)
+	}
+}
@@ -0,0 +1,78 @@
+package backend
+
+import (
+	"errors"
+
+	pb "github.com/mchudgins/certMgr/pkg/service"
+	"golang.org/x/net/context"
+)
+
+// CheckEntitlements dry-runs a request: each name is checked against the CA's name
+// constraints & the caller's entitlements, then the request as a whole against the
+// CA's policy. Nothing is issued.
+func (s *server) CheckEntitlements(ctx context.Context, in *pb.EntitlementsRequest) (*pb.EntitlementsReply, error) {
+	c, err := s.selectCA(ctx, in.GetCa(), in.GetNames())
+	if err != nil {
+		var pe *policyError
+		if errors.As(err, &pe) {
+			return &pb.EntitlementsReply{Permitted: false, Reason: err.Error(), Profile: in.GetProfile()}, nil
+		}
+		return nil, err
+	}
+
+	reply := c.checkEntitlements(ctx, in.GetNames(), in.GetProfile())
+	reply.Ca = c.Name
+	return reply, nil
+}
+
+func (c *ca) checkEntitlements(ctx context.Context, names []string, profileName string) *pb.EntitlementsReply {
+	reply := &pb.EntitlementsReply{Permitted: true, Profile: profileName}
+	refuse := func(err error) {
+		if reply.Permitted {
+			reply.Permitted = false
+			reply.Reason = err.Error()
+		}
+	}
+
+	prof, err := c.lookupProfile(profileName)
+	if err != nil {
+		refuse(err)
+	} else {
+		reply.Profile = prof.Name
+	}
+
+	if len(names) == 0 {
+		refuse(policyErrorf("no names were requested"))
+	}
+
+	// each name is checked against the CA's name constraints & the caller's entitlements
+	var hosts []string
+	for _, r := range c.validateNames(ctx, names, reply.Profile) {
+		result := &pb.NameResult{Name: r.name, Permitted: r.err == nil}
+		if r.err != nil {
+			result.Reason = r.err.Error()
+			refuse(r.err)
+		}
+		reply.Names = append(reply.Names, result)
+		hosts = append(hosts, r.value)
+	}
+
+	if c.Policy != nil {
+		user, groups := requester(ctx), requesterGroups(ctx)
+		for _, r := range c.Policy.rulesFor(user, groups) {
+			e := &pb.Entitlement{Domains: r.domains, Profiles: r.profiles, Wildcards: r.wildcards}
+			for _, ipNet := range r.ipRanges {
+				e.IpRanges = append(e.IpRanges, ipNet.String())
+			}
+			reply.Entitlements = append(reply.Entitlements, e)
+		}
+	}
+
+	if reply.Permitted {
+		if err := prof.checkRequest(hosts); err != nil {
+			refuse(err)
+		}
+	}
+
+	return reply
+}
@@ -0,0 +1,212 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// the encodings in which an issued certificate (& key) may be returned
+const (
+	FormatPEM      = "pem"        // the certificate & key, PEM encoded, as separate files
+	FormatDER      = "der"        // the DER encoded certificate
+	FormatChain    = "chain"      // the certificate followed by the CA chain, PEM encoded
+	FormatCombined = "combined"   // the certificate, CA chain & key in a single PEM file
+	FormatPKCS12   = "pkcs12"     // a password protected PKCS#12 (.p12/.pfx) file
+	FormatJKS      = "jks"        // a password protected Java keystore
+	FormatK8s      = "k8s-secret" // a Kubernetes TLS Secret manifest
+
+	// DefaultFormat is used when the request does not specify a format
+	DefaultFormat = FormatPEM
+)
+
+// SupportedFormats lists every format understood by EncodeCredentials
+var SupportedFormats = []string{
+	FormatPEM,
+	FormatDER,
+	FormatChain,
+	FormatCombined,
+	FormatPKCS12,
+	FormatJKS,
+	FormatK8s,
+}
+
+// normalizeFormat maps an empty format to the default and folds case
+func normalizeFormat(format string) string {
+	if len(format) == 0 {
+		return DefaultFormat
+	}
+	return strings.ToLower(format)
+}
+
+// FormatNeedsKey reports whether the format embeds the private key
+func FormatNeedsKey(format string) bool {
+	switch normalizeFormat(format) {
+	case FormatCombined, FormatPKCS12, FormatJKS, FormatK8s:
+		return true
+	}
+	return false
+}
+
+// FormatNeedsPassword reports whether the format is protected by a password
+func FormatNeedsPassword(format string) bool {
+	switch normalizeFormat(format) {
+	case FormatPKCS12, FormatJKS:
+		return true
+	}
+	return false
+}
+
+// checkFormat refuses an unknown format, or a password protected format without a password
+func checkFormat(format string, password string) error {
+	format = normalizeFormat(format)
+	for _, f := range SupportedFormats {
+		if f == format {
+			if FormatNeedsPassword(format) && len(password) == 0 {
+				return fmt.Errorf("the %s format requires a password", format)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported format %q (expected one of %s)", format, strings.Join(SupportedFormats, ", "))
+}
+
+// EncodeCredentials encodes the PEM certificate, key & CA chain in the requested format.
+// The password protects the pkcs12 & jks formats; the name is the alias of the
+// keystore entry and the basis of the Secret's name.
+func EncodeCredentials(format string, cert string, key string, chain string, password string, name string) ([]byte, error) {
+	if err := checkFormat(format, password); err != nil {
+		return nil, err
+	}
+	format = normalizeFormat(format)
+	if FormatNeedsKey(format) && len(key) == 0 {
+		return nil, fmt.Errorf("the %s format requires the private key", format)
+	}
+
+	certs, err := parseCertificates(cert + chain)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate to encode")
+	}
+
+	switch format {
+	case FormatPEM:
+		return []byte(cert), nil
+
+	case FormatDER:
+		return certs[0].Raw, nil
+
+	case FormatChain:
+		return []byte(cert + chain), nil
+
+	case FormatCombined:
+		return []byte(cert + chain + key), nil
+
+	case FormatPKCS12, FormatJKS:
+		block, _ := pem.Decode([]byte(key))
+		if block == nil {
+			return nil, fmt.Errorf("unable to decode the private key")
+		}
+		priv, err := parseCAKey(block, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if format == FormatJKS {
+			return encodeJKS(keystoreAlias(name), priv, certs, password)
+		}
+		return pkcs12.Modern.Encode(priv, certs[0], certs[1:], password)
+
+	case FormatK8s:
+		return encodeK8sSecret(secretName(name, certs[0]), cert, key, chain), nil
+	}
+
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+// parseCertificates decodes every PEM certificate in the data
+func parseCertificates(data string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+
+	rest := []byte(data)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// keystoreAlias returns the alias of the keystore entry (Java folds aliases to lower case)
+func keystoreAlias(name string) string {
+	if len(name) == 0 {
+		return "certificate"
+	}
+	return strings.ToLower(name)
+}
+
+// secretName derives a Kubernetes object name from the common name, e.g.
+// *.web.dstcorp.io becomes wildcard-web-dstcorp-io-tls
+func secretName(name string, cert *x509.Certificate) string {
+	if len(name) == 0 {
+		name = cert.Subject.CommonName
+	}
+	name = strings.Replace(strings.ToLower(name), "*", "wildcard", -1)
+
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('-')
+		}
+	}
+
+	name = strings.Trim(b.String(), "-")
+	if len(name) > 249 {
+		name = strings.TrimRight(name[:249], "-")
+	}
+	if len(name) == 0 {
+		name = "certificate"
+	}
+	return name + "-tls"
+}
+
+// encodeK8sSecret returns a kubernetes.io/tls Secret holding the certificate
+// (followed by the chain, as ingress controllers expect) & key. The CA chain
+// is also provided as ca.crt.
+func encodeK8sSecret(name string, cert string, key string, chain string) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "apiVersion: v1\n")
+	fmt.Fprintf(&b, "kind: Secret\n")
+	fmt.Fprintf(&b, "metadata:\n")
+	fmt.Fprintf(&b, "  name: %s\n", name)
+	fmt.Fprintf(&b, "type: kubernetes.io/tls\n")
+	fmt.Fprintf(&b, "data:\n")
+	if len(chain) > 0 {
+		fmt.Fprintf(&b, "  ca.crt: %s\n", base64.StdEncoding.EncodeToString([]byte(chain)))
+	}
+	fmt.Fprintf(&b, "  tls.crt: %s\n", base64.StdEncoding.EncodeToString([]byte(cert+chain)))
+	fmt.Fprintf(&b, "  tls.key: %s\n", base64.StdEncoding.EncodeToString([]byte(key)))
+
+	return b.Bytes()
+}
@@ -0,0 +1,268 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// issueTestCredentials returns a certificate, key & chain from a test CA
+func issueTestCredentials(t *testing.T, keyType string) (string, string, string) {
+	c := newTestCA(t)
+	c.Bundle = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.SigningCertificate.Raw}))
+
+	cert, key, err := c.CreateCertificate(context.Background(), "api.dstcorp.io", nil, 24*time.Hour, keyType, "", pkix.Name{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, c.Bundle
+}
+
+// jksRecoverKey decrypts a protected key, returning the PKCS#8 key
+func jksRecoverKey(password []byte, der []byte) ([]byte, error) {
+	var key jksEncryptedKey
+	if _, err := asn1.Unmarshal(der, &key); err != nil {
+		return nil, err
+	}
+	if !key.Algorithm.Algorithm.Equal(oidJKSKeyProtector) {
+		return nil, fmt.Errorf("unsupported key protection algorithm %s", key.Algorithm.Algorithm)
+	}
+
+	protected := key.EncryptedData
+	salt := protected[:jksKeyProtectSalt]
+	pkcs8 := jksKeystream(password, salt, protected[jksKeyProtectSalt:len(protected)-sha1.Size])
+
+	check := sha1.New()
+	check.Write(password)
+	check.Write(pkcs8)
+	if !bytes.Equal(check.Sum(nil), protected[len(protected)-sha1.Size:]) {
+		return nil, ErrIncorrectPassphrase
+	}
+	return pkcs8, nil
+}
+
+// decodeJKS reads a keystore of a single private key entry, as a JVM would
+func decodeJKS(data []byte, password string) (string, interface{}, []*x509.Certificate, error) {
+	pw := jksPassword(password)
+
+	if len(data) < sha1.Size {
+		return "", nil, nil, io.ErrUnexpectedEOF
+	}
+	body, digest := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	integrity := sha1.New()
+	integrity.Write(pw)
+	integrity.Write([]byte(jksIntegritySalt))
+	integrity.Write(body)
+	if !bytes.Equal(integrity.Sum(nil), digest) {
+		return "", nil, nil, fmt.Errorf("the keystore has been tampered with, or the password is incorrect")
+	}
+
+	r := bytes.NewReader(body)
+	var header struct{ Magic, Version, Count, Tag uint32 }
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return "", nil, nil, err
+	}
+	if header.Magic != jksMagic || header.Version != jksVersion || header.Count != 1 || header.Tag != jksPrivateKeyTag {
+		return "", nil, nil, fmt.Errorf("unexpected keystore header %+v", header)
+	}
+
+	readUTF := func() string {
+		var n uint16
+		binary.Read(r, binary.BigEndian, &n)
+		s := make([]byte, n)
+		io.ReadFull(r, s)
+		return string(s)
+	}
+	readBytes := func() []byte {
+		var n uint32
+		binary.Read(r, binary.BigEndian, &n)
+		b := make([]byte, n)
+		io.ReadFull(r, b)
+		return b
+	}
+
+	alias := readUTF()
+	var timestamp uint64
+	binary.Read(r, binary.BigEndian, &timestamp)
+
+	pkcs8, err := jksRecoverKey(pw, readBytes())
+	if err != nil {
+		return "", nil, nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(pkcs8)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	var count uint32
+	binary.Read(r, binary.BigEndian, &count)
+	var chain []*x509.Certificate
+	for i := uint32(0); i < count; i++ {
+		if certType := readUTF(); certType != "X.509" {
+			return "", nil, nil, fmt.Errorf("unexpected certificate type %q", certType)
+		}
+		cert, err := x509.ParseCertificate(readBytes())
+		if err != nil {
+			return "", nil, nil, err
+		}
+		chain = append(chain, cert)
+	}
+
+	if r.Len() != 0 {
+		return "", nil, nil, fmt.Errorf("%d bytes follow the entry", r.Len())
+	}
+	return alias, key, chain, nil
+}
+
+// checkKeyPair checks the key belongs to the certificate
+func checkKeyPair(t *testing.T, format string, cert *x509.Certificate, key interface{}) {
+	keyPEM := pem.EncodeToMemory(pemBlockForKey(key))
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		t.Errorf("%s: the key does not match the certificate: %s", format, err)
+	}
+}
+
+func TestFormats(t *testing.T) {
+	for _, keyType := range []string{KeyTypeECDSAP256, KeyTypeRSA2048, KeyTypeEd25519} {
+		cert, key, chain := issueTestCredentials(t, keyType)
+		leaf, err := parseCertificates(cert)
+		if err != nil || len(leaf) != 1 {
+			t.Fatalf("unable to parse the certificate: %v", err)
+		}
+		ca, err := parseCertificates(chain)
+		if err != nil || len(ca) != 1 {
+			t.Fatalf("unable to parse the chain: %v", err)
+		}
+
+		encode := func(format string, password string) []byte {
+			content, err := EncodeCredentials(format, cert, key, chain, password, "api.dstcorp.io")
+			if err != nil {
+				t.Fatalf("%s (%s): %s", format, keyType, err)
+			}
+			return content
+		}
+
+		// der
+		der, err := x509.ParseCertificate(encode(FormatDER, ""))
+		if err != nil || !der.Equal(leaf[0]) {
+			t.Errorf("der (%s): expected the certificate, got %v", keyType, err)
+		}
+
+		// chain
+		certs, err := parseCertificates(string(encode(FormatChain, "")))
+		if err != nil || len(certs) != 2 || !certs[0].Equal(leaf[0]) || !certs[1].Equal(ca[0]) {
+			t.Errorf("chain (%s): expected the certificate & CA, got %d certificates: %v", keyType, len(certs), err)
+		}
+
+		// combined
+		combined := encode(FormatCombined, "")
+		certs, err = parseCertificates(string(combined))
+		if err != nil || len(certs) != 2 {
+			t.Errorf("combined (%s): expected the certificate & CA, got %d certificates: %v", keyType, len(certs), err)
+		}
+		if _, err = tls.X509KeyPair(combined, combined); err != nil {
+			t.Errorf("combined (%s): %s", keyType, err)
+		}
+
+		// pkcs12
+		p12Key, p12Cert, p12CAs, err := pkcs12.DecodeChain(encode(FormatPKCS12, "changeit"), "changeit")
+		if err != nil {
+			t.Errorf("pkcs12 (%s): %s", keyType, err)
+		} else {
+			if !p12Cert.Equal(leaf[0]) || len(p12CAs) != 1 || !p12CAs[0].Equal(ca[0]) {
+				t.Errorf("pkcs12 (%s): unexpected certificates", keyType)
+			}
+			checkKeyPair(t, "pkcs12", p12Cert, p12Key)
+		}
+		if _, _, _, err = pkcs12.DecodeChain(encode(FormatPKCS12, "changeit"), "letmein"); err == nil {
+			t.Errorf("pkcs12 (%s): expected the wrong password to be refused", keyType)
+		}
+
+		// jks
+		jks := encode(FormatJKS, "changeit")
+		alias, jksKey, jksChain, err := decodeJKS(jks, "changeit")
+		if err != nil {
+			t.Errorf("jks (%s): %s", keyType, err)
+		} else {
+			if alias != "api.dstcorp.io" || len(jksChain) != 2 || !jksChain[0].Equal(leaf[0]) || !jksChain[1].Equal(ca[0]) {
+				t.Errorf("jks (%s): unexpected entry %s with %d certificates", keyType, alias, len(jksChain))
+			}
+			checkKeyPair(t, "jks", jksChain[0], jksKey)
+		}
+		if _, _, _, err = decodeJKS(jks, "letmein"); err == nil {
+			t.Errorf("jks (%s): expected the wrong password to be refused", keyType)
+		}
+
+		// k8s-secret
+		v := viper.New()
+		v.SetConfigType("yaml")
+		if err = v.ReadConfig(bytes.NewReader(encode(FormatK8s, ""))); err != nil {
+			t.Fatalf("k8s-secret (%s): %s", keyType, err)
+		}
+		if v.GetString("kind") != "Secret" || v.GetString("type") != "kubernetes.io/tls" ||
+			v.GetString("metadata.name") != "api-dstcorp-io-tls" {
+			t.Errorf("k8s-secret (%s): unexpected secret %v", keyType, v.AllSettings())
+		}
+		data := map[string][]byte{}
+		for name, value := range v.GetStringMapString("data") {
+			if data[name], err = base64.StdEncoding.DecodeString(value); err != nil {
+				t.Fatalf("k8s-secret (%s): %s: %s", keyType, name, err)
+			}
+		}
+		if _, err = tls.X509KeyPair(data["tls.crt"], data["tls.key"]); err != nil {
+			t.Errorf("k8s-secret (%s): %s", keyType, err)
+		}
+		if string(data["ca.crt"]) != chain {
+			t.Errorf("k8s-secret (%s): expected ca.crt to be the chain", keyType)
+		}
+	}
+}
+
+func TestFormatRequirements(t *testing.T) {
+	cert, key, chain := issueTestCredentials(t, "")
+
+	tests := []struct {
+		format   string
+		key      string
+		password string
+		failure  string
+	}{
+		{"pfx", key, "", "unsupported format"},
+		{FormatPKCS12, key, "", "requires a password"},
+		{FormatJKS, key, "", "requires a password"},
+		{FormatCombined, "", "", "requires the private key"},
+		{FormatK8s, "", "", "requires the private key"},
+		{"DER", "", "", ""},
+		{"", "", "", ""},
+	}
+
+	if name := secretName("*.Web.dstcorp.io", nil); name != "wildcard-web-dstcorp-io-tls" {
+		t.Errorf("unexpected secret name %s", name)
+	}
+
+	for _, test := range tests {
+		_, err := EncodeCredentials(test.format, cert, test.key, chain, test.password, "")
+		if len(test.failure) == 0 && err != nil {
+			t.Errorf("%q: %s", test.format, err)
+		}
+		if len(test.failure) > 0 && (err == nil || !strings.Contains(err.Error(), test.failure)) {
+			t.Errorf("%q: expected %q, got %v", test.format, test.failure, err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package backend
+
+import (
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+)
+
+// subjectPublicKeyBits returns the subjectPublicKey BIT STRING of a
+// DER encoded SubjectPublicKeyInfo
+func subjectPublicKeyBits(spkiDER []byte) ([]byte, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(spkiDER, &spki); err != nil {
+		return nil, err
+	}
+	return spki.PublicKey.RightAlign(), nil
+}
+
+// subjectKeyID computes a key identifier using method 1 of RFC 5280 section 4.2.1.2:
+// the SHA-1 hash of the subjectPublicKey. It works for every supported key type.
+func subjectKeyID(pub interface{}) ([]byte, error) {
+	spkiDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	bits, err := subjectPublicKeyBits(spkiDER)
+	if err != nil {
+		return nil, err
+	}
+
+	id := sha1.Sum(bits)
+	return id[:], nil
+}
+
+// addIssuerInfo stamps the key identifiers, the authority information access
+// and the CRL distribution points into a certificate template
+func (c ca) addIssuerInfo(template *x509.Certificate, pub interface{}) error {
+	var err error
+
+	if len(template.SubjectKeyId) == 0 {
+		template.SubjectKeyId, err = subjectKeyID(pub)
+		if err != nil {
+			return err
+		}
+	}
+
+	// x509.CreateCertificate takes the authority key id from the issuer's
+	// subject key id, which older CA certificates may lack
+	template.AuthorityKeyId = c.SigningCertificate.SubjectKeyId
+	if len(template.AuthorityKeyId) == 0 {
+		template.AuthorityKeyId, err = subjectKeyID(c.SigningCertificate.PublicKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	template.IssuingCertificateURL = c.IssuerURLs
+	template.OCSPServer = c.OCSPURLs
+	template.CRLDistributionPoints = c.CRLURLs
+
+	return nil
+}
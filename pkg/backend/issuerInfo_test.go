@@ -0,0 +1,62 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestIssuerInfo(t *testing.T) {
+	c := newTestCA(t)
+	c.IssuerURLs = []string{"http://test.dstcorp.io/test.crt"}
+	c.OCSPURLs = []string{"http://ocsp.test.dstcorp.io:9080"}
+	c.CRLURLs = []string{"http://test.dstcorp.io/test.crl"}
+
+	// the authority key id is computed when the issuer lacks a subject key id
+	c.SigningCertificate.SubjectKeyId = nil
+	caKeyID, err := subjectKeyID(c.SigningCertificate.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, keyType := range SupportedKeyTypes {
+		certPEM, _, err := c.CreateCertificate(context.Background(), "foo.dstcorp.io", []string{"foo.dstcorp.io"},
+			24*time.Hour, keyType, "", pkix.Name{}, nil)
+		if err != nil {
+			t.Fatalf("%s: CreateCertificate: %s", keyType, err)
+		}
+
+		block, _ := pem.Decode([]byte(certPEM))
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keyID, err := subjectKeyID(cert.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(cert.SubjectKeyId, keyID) {
+			t.Errorf("%s: expected subject key id %x, got %x", keyType, keyID, cert.SubjectKeyId)
+		}
+		if !bytes.Equal(cert.AuthorityKeyId, caKeyID) {
+			t.Errorf("%s: expected authority key id %x, got %x", keyType, caKeyID, cert.AuthorityKeyId)
+		}
+
+		if !reflect.DeepEqual(cert.IssuingCertificateURL, c.IssuerURLs) {
+			t.Errorf("%s: expected caIssuers %v, got %v", keyType, c.IssuerURLs, cert.IssuingCertificateURL)
+		}
+		if !reflect.DeepEqual(cert.OCSPServer, c.OCSPURLs) {
+			t.Errorf("%s: expected OCSP %v, got %v", keyType, c.OCSPURLs, cert.OCSPServer)
+		}
+		if !reflect.DeepEqual(cert.CRLDistributionPoints, c.CRLURLs) {
+			t.Errorf("%s: expected CRL distribution points %v, got %v", keyType, c.CRLURLs, cert.CRLDistributionPoints)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package backend
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"time"
+	"unicode/utf16"
+)
+
+// Java keystores (JKS), as written by sun.security.provider.JavaKeyStore:
+//
+//	magic 0xfeedfeed, version 2, # of entries
+//	per private key entry: tag 1, alias, timestamp (ms), protected key, chain
+//	SHA-1(password || "Mighty Aphrodite" || everything above)
+//
+// The key is protected by Sun's proprietary KeyProtector: the PKCS#8 key is
+// XOR'd with a SHA-1 keystream seeded by a random salt.
+
+const (
+	jksMagic          = 0xfeedfeed
+	jksVersion        = 2
+	jksPrivateKeyTag  = 1
+	jksIntegritySalt  = "Mighty Aphrodite"
+	jksKeyProtectSalt = sha1.Size
+)
+
+// the object identifier of Sun's key protection algorithm
+var oidJKSKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+// the protected key, an EncryptedPrivateKeyInfo
+type jksEncryptedKey struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// jksPassword returns the password as Java's UTF-16BE char[] bytes
+func jksPassword(password string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(password)) {
+		b = append(b, byte(c>>8), byte(c))
+	}
+	return b
+}
+
+// jksKeystream XORs the data with the keystream derived from the password & salt
+func jksKeystream(password []byte, salt []byte, data []byte) []byte {
+	out := make([]byte, len(data))
+	digest := salt
+	for i := 0; i < len(data); i += sha1.Size {
+		h := sha1.New()
+		h.Write(password)
+		h.Write(digest)
+		digest = h.Sum(nil)
+
+		for j := 0; j < sha1.Size && i+j < len(data); j++ {
+			out[i+j] = data[i+j] ^ digest[j]
+		}
+	}
+	return out
+}
+
+// jksProtectKey encrypts the PKCS#8 key: salt || encrypted key || SHA-1(password || key)
+func jksProtectKey(password []byte, pkcs8 []byte) ([]byte, error) {
+	salt := make([]byte, jksKeyProtectSalt)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	check := sha1.New()
+	check.Write(password)
+	check.Write(pkcs8)
+
+	protected := append(salt, jksKeystream(password, salt, pkcs8)...)
+	protected = append(protected, check.Sum(nil)...)
+
+	return asn1.Marshal(jksEncryptedKey{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidJKSKeyProtector, Parameters: asn1.NullRawValue},
+		EncryptedData: protected,
+	})
+}
+
+// jksWriteUTF writes the string as Java's DataOutput.writeUTF does (for the BMP)
+func jksWriteUTF(b *bytes.Buffer, s string) {
+	binary.Write(b, binary.BigEndian, uint16(len(s)))
+	b.WriteString(s)
+}
+
+// encodeJKS returns a keystore holding the key & its certificate chain under the alias
+func encodeJKS(alias string, key crypto.PrivateKey, chain []*x509.Certificate, password string) ([]byte, error) {
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	pw := jksPassword(password)
+	protected, err := jksProtectKey(pw, pkcs8)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint32(jksMagic))
+	binary.Write(&b, binary.BigEndian, uint32(jksVersion))
+	binary.Write(&b, binary.BigEndian, uint32(1))
+
+	binary.Write(&b, binary.BigEndian, uint32(jksPrivateKeyTag))
+	jksWriteUTF(&b, alias)
+	binary.Write(&b, binary.BigEndian, uint64(time.Now().UnixNano()/int64(time.Millisecond)))
+	binary.Write(&b, binary.BigEndian, uint32(len(protected)))
+	b.Write(protected)
+
+	binary.Write(&b, binary.BigEndian, uint32(len(chain)))
+	for _, cert := range chain {
+		jksWriteUTF(&b, "X.509")
+		binary.Write(&b, binary.BigEndian, uint32(len(cert.Raw)))
+		b.Write(cert.Raw)
+	}
+
+	integrity := sha1.New()
+	integrity.Write(pw)
+	integrity.Write([]byte(jksIntegritySalt))
+	integrity.Write(b.Bytes())
+	b.Write(integrity.Sum(nil))
+
+	return b.Bytes(), nil
+}
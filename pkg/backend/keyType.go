@@ -0,0 +1,107 @@
+package backend
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+)
+
+// the key algorithms & sizes which may be generated for a certificate
+const (
+	KeyTypeECDSAP256 = "ecdsa-p256"
+	KeyTypeECDSAP384 = "ecdsa-p384"
+	KeyTypeRSA2048   = "rsa-2048"
+	KeyTypeRSA3072   = "rsa-3072"
+	KeyTypeRSA4096   = "rsa-4096"
+	KeyTypeEd25519   = "ed25519"
+
+	// DefaultKeyType is used when the request does not specify a key type
+	DefaultKeyType = KeyTypeECDSAP256
+)
+
+// SupportedKeyTypes lists every key type understood by generateKey
+var SupportedKeyTypes = []string{
+	KeyTypeECDSAP256,
+	KeyTypeECDSAP384,
+	KeyTypeRSA2048,
+	KeyTypeRSA3072,
+	KeyTypeRSA4096,
+	KeyTypeEd25519,
+}
+
+// normalizeKeyType maps an empty key type to the default and folds case
+func normalizeKeyType(keyType string) string {
+	if len(keyType) == 0 {
+		return DefaultKeyType
+	}
+	return strings.ToLower(keyType)
+}
+
+// generateKey creates a new private key of the specified type
+func generateKey(keyType string) (crypto.Signer, error) {
+	switch normalizeKeyType(keyType) {
+	case KeyTypeECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyTypeRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyTypeRSA3072:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case KeyTypeRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case KeyTypeEd25519:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	default:
+		return nil, policyErrorf("key type %s is not supported (supported types: %s)",
+			keyType, strings.Join(SupportedKeyTypes, ", "))
+	}
+}
+
+// permitsKeyType returns true if the CA's allowlist includes the key type.
+// An empty allowlist permits every supported key type.
+func (c *ca) permitsKeyType(keyType string) bool {
+	if len(c.KeyTypes) == 0 {
+		return true
+	}
+
+	keyType = normalizeKeyType(keyType)
+	for _, kt := range c.KeyTypes {
+		if strings.ToLower(kt) == keyType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// keyTypeOf returns the key type of a public key, or an empty string
+// if the key is not one generateKey would produce
+func keyTypeOf(pub interface{}) string {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return KeyTypeECDSAP256
+		case elliptic.P384():
+			return KeyTypeECDSAP384
+		}
+	case *rsa.PublicKey:
+		switch k.N.BitLen() {
+		case 2048:
+			return KeyTypeRSA2048
+		case 3072:
+			return KeyTypeRSA3072
+		case 4096:
+			return KeyTypeRSA4096
+		}
+	case ed25519.PublicKey:
+		return KeyTypeEd25519
+	}
+	return ""
+}
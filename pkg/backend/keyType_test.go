@@ -0,0 +1,142 @@
+package backend
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestGenerateKey(t *testing.T) {
+	tests := []struct {
+		keyType  string
+		expected string // the key type generated, empty if refused
+	}{
+		{"", KeyTypeECDSAP256},
+		{KeyTypeECDSAP256, KeyTypeECDSAP256},
+		{"ECDSA-P384", KeyTypeECDSAP384},
+		{KeyTypeRSA2048, KeyTypeRSA2048},
+		{KeyTypeRSA3072, KeyTypeRSA3072},
+		{KeyTypeRSA4096, KeyTypeRSA4096},
+		{KeyTypeEd25519, KeyTypeEd25519},
+		{"dsa-1024", ""},
+	}
+
+	for _, test := range tests {
+		key, err := generateKey(test.keyType)
+		if len(test.expected) == 0 {
+			if _, ok := err.(*policyError); !ok {
+				t.Errorf("%q: expected a policy error, got %v", test.keyType, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.keyType, err)
+			continue
+		}
+		if keyType := keyTypeOf(key.Public()); keyType != test.expected {
+			t.Errorf("%q: expected a %s key, got %q", test.keyType, test.expected, keyType)
+		}
+	}
+}
+
+func TestKeyTypeOf(t *testing.T) {
+	// keys generateKey would not produce have no key type
+	p521, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyType := keyTypeOf(p521.Public()); len(keyType) != 0 {
+		t.Errorf("expected no key type for P-521, got %s", keyType)
+	}
+	if keyType := keyTypeOf("not a key"); len(keyType) != 0 {
+		t.Errorf("expected no key type, got %s", keyType)
+	}
+}
+
+func TestPermitsKeyType(t *testing.T) {
+	c := &ca{}
+	for _, keyType := range append(SupportedKeyTypes, "") {
+		if !c.permitsKeyType(keyType) {
+			t.Errorf("%q: expected every key type to be permitted without an allowlist", keyType)
+		}
+	}
+
+	c.KeyTypes = []string{"ECDSA-P256", KeyTypeEd25519}
+	for keyType, permitted := range map[string]bool{
+		"":               true, // the default
+		KeyTypeECDSAP256: true,
+		"ED25519":        true,
+		KeyTypeRSA2048:   false,
+		"dsa-1024":       false,
+	} {
+		if c.permitsKeyType(keyType) != permitted {
+			t.Errorf("%q: expected permitted=%t", keyType, permitted)
+		}
+	}
+}
+
+func TestRequestKeyTypes(t *testing.T) {
+	c := newTestCA(t)
+	c.KeyTypes = []string{KeyTypeECDSAP256, KeyTypeEd25519}
+	ctx := context.Background()
+
+	_, _, err := c.CreateCertificate(ctx, "foo.dstcorp.io", nil, day, KeyTypeRSA2048, "", pkix.Name{}, nil)
+	if _, ok := err.(*policyError); !ok {
+		t.Errorf("expected the RSA key to be refused, got %v", err)
+	}
+
+	rsa1024, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p521, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsa2048, err := generateKey(KeyTypeRSA2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed25519, err := generateKey(KeyTypeEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     crypto.Signer
+		refusal string // substring of the expected refusal, empty if signed
+	}{
+		{"rsa-1024", rsa1024, "not a supported key type"},
+		{"ecdsa-p521", p521, "not a supported key type"},
+		{"rsa-2048", rsa2048, "key type rsa-2048 is not permitted"},
+		{"ed25519", ed25519, ""},
+	}
+
+	for _, test := range tests {
+		der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+			Subject: pkix.Name{CommonName: "foo.dstcorp.io"}}, test.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		csrPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+
+		_, err = c.SignCertificateRequest(ctx, csrPEM, day, "server", nil)
+		if len(test.refusal) > 0 {
+			if _, ok := err.(*policyError); !ok || !strings.Contains(err.Error(), test.refusal) {
+				t.Errorf("%s: expected a policy error %q, got %v", test.name, test.refusal, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+	}
+}
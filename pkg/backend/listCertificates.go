@@ -0,0 +1,105 @@
+package backend
+
+import (
+	"strings"
+	"time"
+
+	pb "github.com/mchudgins/certMgr/pkg/service"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ListCertificates returns a page of the certificate inventory
+func (s *server) ListCertificates(ctx context.Context, in *pb.ListRequest) (*pb.ListReply, error) {
+	filter := &CertificateFilter{
+		SAN:       in.GetSan(),
+		SANSuffix: in.GetSanSuffix(),
+		Requester: in.GetRequester(),
+		CA:        in.GetCa(),
+	}
+
+	if in.GetExpiringBefore() != 0 {
+		filter.ExpiringBefore = time.Unix(in.GetExpiringBefore(), 0)
+	}
+
+	switch in.GetStatus() {
+	case pb.RevocationStatus_STATUS_VALID:
+		filter.RevocationStatus = RevocationStatusValid
+	case pb.RevocationStatus_STATUS_REVOKED:
+		filter.RevocationStatus = RevocationStatusRevoked
+	}
+
+	for _, label := range in.GetLabels() {
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 {
+			return nil, status.Errorf(codes.InvalidArgument, "label %s is not of the form key=value", label)
+		}
+		if filter.Labels == nil {
+			filter.Labels = make(map[string]string)
+		}
+		filter.Labels[kv[0]] = kv[1]
+	}
+
+	var pageToken string
+	if len(in.GetPageToken()) > 0 {
+		pageToken = normalizeSerial(in.GetPageToken())
+	}
+
+	records, next, err := s.store.List(ctx, filter, pageToken, int(in.GetPageSize()))
+	if err != nil {
+		log.WithError(err).Error("Unable to list the certificate inventory")
+		return nil, status.Errorf(codes.Internal, "unable to list certificates: %s", err)
+	}
+
+	reply := &pb.ListReply{NextPageToken: next}
+	for _, rec := range records {
+		reply.Certificates = append(reply.Certificates, certificateFromRecord(rec, false))
+	}
+
+	return reply, nil
+}
+
+// GetCertificate returns an issued certificate by serial number
+func (s *server) GetCertificate(ctx context.Context, in *pb.GetRequest) (*pb.GetReply, error) {
+	rec, err := s.store.Get(ctx, in.GetSerial())
+	if err == ErrCertificateNotFound {
+		return nil, status.Errorf(codes.NotFound, "certificate %s not found", in.GetSerial())
+	}
+	if err != nil {
+		log.WithError(err).WithField("serial", in.GetSerial()).Error("Unable to retrieve certificate")
+		return nil, status.Errorf(codes.Internal, "unable to retrieve certificate %s: %s", in.GetSerial(), err)
+	}
+
+	return &pb.GetReply{Certificate: certificateFromRecord(rec, true)}, nil
+}
+
+// certificateFromRecord converts an inventory record to its protobuf equivalent
+func certificateFromRecord(rec *CertificateRecord, includePEM bool) *pb.Certificate {
+	cert := &pb.Certificate{
+		Serial:         rec.Serial,
+		CommonName:     rec.CommonName,
+		AlternateNames: rec.SANs,
+		Requester:      rec.Requester,
+		Ca:             rec.CA,
+		Profile:        rec.Profile,
+		NotBefore:      rec.NotBefore.Unix(),
+		NotAfter:       rec.NotAfter.Unix(),
+		Revoked:        rec.Revoked,
+		Labels:         rec.Labels,
+		Renews:         rec.Renews,
+		RenewedBy:      rec.RenewedBy,
+	}
+
+	if rec.Revoked {
+		cert.RevokedAt = rec.RevokedAt.Unix()
+		cert.RevocationReason = int32(rec.RevocationReason)
+	}
+
+	if includePEM {
+		cert.Certificate = rec.PEM
+	}
+
+	return cert
+}
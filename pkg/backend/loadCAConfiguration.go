@@ -5,6 +5,8 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"fmt"
+	"regexp"
 	"strings"
 
 	"time"
@@ -18,7 +20,8 @@ import (
 func NewCertificateAuthority(caName string,
 	certFile string,
 	keyFile string,
-	bundleFile string) (*ca, error) {
+	bundleFile string,
+	passphrase PassphraseFunc) (*ca, error) {
 	cert, err := utils.FindAndReadFile(certFile, "certificate")
 	if err != nil {
 		return nil, err
@@ -33,8 +36,9 @@ func NewCertificateAuthority(caName string,
 	if err != nil {
 		return nil, err
 	}
+	root, _ := loadAsset("static/root-ca.crt")
 
-	return createCA(caName, []byte(cert), []byte(key), bundle)
+	return createCA(caName, []byte(cert), []byte(key), completeBundle(bundle, root), passphrase)
 }
 
 func loadAsset(asset string) (string, error) {
@@ -46,10 +50,155 @@ func loadAsset(asset string) (string, error) {
 	return string(b), nil
 }
 
-func NewCertificateAuthorityFromConfig(cfg *certMgr.AppConfig) (*ca, error) {
+// signingCACertificate returns the certificate of the configured (primary) signing CA
+func signingCACertificate(cfg *certMgr.AppConfig) (*x509.Certificate, error) {
+	var err error
+
+	if len(cfg.Backend.CAs) > 0 {
+		cfg = caAppConfig(cfg, cfg.Backend.CAs[0])
+	}
+
+	cert := cfg.Backend.SigningCACertificate
+	if len(cert) == 0 {
+		cert, err = loadAsset("static/signing-ca.crt")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	block, _ := pem.Decode([]byte(cert))
+	if block == nil {
+		return nil, errors.New("Unable to decode the signing CA's certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// caNamePattern restricts CA names to those usable in URL paths & file names
+var caNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// caAppConfig returns the configuration of one of the backend's declared CAs: the
+// backend's settings, overridden by those of the CA
+func caAppConfig(cfg *certMgr.AppConfig, c certMgr.CAConfig) *certMgr.AppConfig {
+	named := *cfg
+	b := &named.Backend
+	b.CAs = nil
+
+	if len(c.SigningCACertificate) > 0 {
+		b.SigningCACertificate = c.SigningCACertificate
+	}
+	if len(c.Bundle) > 0 {
+		b.Bundle = c.Bundle
+	}
+	if len(c.RootCACertificate) > 0 {
+		b.RootCACertificate = c.RootCACertificate
+	}
+
+	// a CA naming its own key uses only that key source
+	if len(c.SigningCAKeyFilename) > 0 || len(c.PKCS11.Module) > 0 || len(c.RemoteSigner.Address) > 0 {
+		timeout := b.RemoteSigner.Timeout
+		b.SigningCAKeyFilename, b.PKCS11, b.RemoteSigner = c.SigningCAKeyFilename, c.PKCS11, c.RemoteSigner
+		if b.RemoteSigner.Timeout == 0 {
+			b.RemoteSigner.Timeout = timeout
+		}
+	}
+	if len(c.CAKeyPassphraseEnv) > 0 {
+		b.CAKeyPassphraseEnv = c.CAKeyPassphraseEnv
+	}
+	if len(c.CAKeyPassphraseFile) > 0 {
+		b.CAKeyPassphraseFile = c.CAKeyPassphraseFile
+	}
+
+	if c.MaxDuration != nil {
+		b.MaxDuration = *c.MaxDuration
+	}
+	if c.ClampDuration != nil {
+		b.ClampDuration = *c.ClampDuration
+	}
+	if len(c.AllowedKeyTypes) > 0 {
+		b.AllowedKeyTypes = c.AllowedKeyTypes
+	}
+	if len(c.Profiles) > 0 {
+		b.Profiles = c.Profiles
+	}
+	if len(c.AllowedProfiles) > 0 {
+		b.AllowedProfiles = c.AllowedProfiles
+	}
+	if len(c.DefaultProfile) > 0 {
+		b.DefaultProfile = c.DefaultProfile
+	}
+	if c.Subject != nil {
+		b.Subject = *c.Subject
+	}
+	if len(c.PolicyFilename) > 0 {
+		b.PolicyFilename = c.PolicyFilename
+	}
+	if c.ApexWildcards != nil {
+		b.ApexWildcards = *c.ApexWildcards
+	}
+	if len(c.IssuerURL) > 0 {
+		b.IssuerURL = c.IssuerURL
+	}
+	if len(c.OCSPURL) > 0 {
+		b.OCSPURL = c.OCSPURL
+	}
+	if len(c.CRLURLs) > 0 {
+		b.CRLURLs = c.CRLURLs
+	}
+
+	// each CA numbers its own CRLs
+	if len(c.CRLNumberFilename) > 0 {
+		b.CRLNumberFilename = c.CRLNumberFilename
+	} else {
+		b.CRLNumberFilename += "." + c.Name
+	}
+
+	return &named
+}
+
+// NewCertificateAuthoritiesFromConfig creates every CA hosted by the backend, the
+// primary first, along with the configuration of each
+func NewCertificateAuthoritiesFromConfig(cfg *certMgr.AppConfig) ([]*ca, []*certMgr.AppConfig, error) {
+	if len(cfg.Backend.CAs) == 0 {
+		c, err := NewCertificateAuthorityFromConfig("", cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []*ca{c}, []*certMgr.AppConfig{cfg}, nil
+	}
+
+	var cas []*ca
+	var configs []*certMgr.AppConfig
+	names := map[string]bool{}
+	for _, caCfg := range cfg.Backend.CAs {
+		if !caNamePattern.MatchString(caCfg.Name) {
+			return nil, nil, fmt.Errorf("%q is not a valid CA name", caCfg.Name)
+		}
+		if names[caCfg.Name] {
+			return nil, nil, fmt.Errorf("the CA %s is declared more than once", caCfg.Name)
+		}
+		names[caCfg.Name] = true
+
+		named := caAppConfig(cfg, caCfg)
+		c, err := NewCertificateAuthorityFromConfig(caCfg.Name, named)
+		if err != nil {
+			return nil, nil, fmt.Errorf("CA %s: %s", caCfg.Name, err)
+		}
+		for _, other := range cas {
+			if other.SigningCertificate.Equal(&c.SigningCertificate) {
+				return nil, nil, fmt.Errorf("the CAs %s and %s have the same signing certificate", other.Name, c.Name)
+			}
+		}
+
+		cas = append(cas, c)
+		configs = append(configs, named)
+	}
+
+	return cas, configs, nil
+}
+
+// NewCertificateAuthorityFromConfig creates the CA described by the backend's configuration
+func NewCertificateAuthorityFromConfig(caName string, cfg *certMgr.AppConfig) (*ca, error) {
 	var err error
-	duration := time.Duration(cfg.Backend.MaxDuration)
-	_ = duration
 
 	// find the public portion of the Signing CA
 	cert := cfg.Backend.SigningCACertificate
@@ -69,17 +218,94 @@ func NewCertificateAuthorityFromConfig(cfg *certMgr.AppConfig) (*ca, error) {
 		}
 	}
 
-	key, err := utils.FindAndReadFile(cfg.Backend.SigningCAKeyFilename, "CA key")
+	// a bundle stopping at an intermediate is completed by the root
+	root := cfg.Backend.RootCACertificate
+	if len(root) == 0 {
+		root, _ = loadAsset("static/root-ca.crt")
+	}
+	bundle = completeBundle(bundle, root)
+
+	signer, err := openTokenSigner(cfg.Backend)
 	if err != nil {
-		log.WithError(err).Fatalf("Application misconfigured, exiting")
+		return nil, err
 	}
 
-	return createCA("", []byte(cert), []byte(key), bundle)
+	var ca *ca
+	if signer != nil {
+		// the CA key is held outside the process
+		ca, err = newCA(caName, []byte(cert), signer, bundle)
+		if err != nil {
+			signer.Close()
+			return nil, err
+		}
+	} else {
+		key, err := utils.FindAndReadFile(cfg.Backend.SigningCAKeyFilename, "CA key")
+		if err != nil {
+			log.WithError(err).Fatalf("Application misconfigured, exiting")
+		}
+
+		ca, err = createCA(caName, []byte(cert), []byte(key), bundle, PassphraseFromConfig(cfg))
+		if err != nil {
+			return nil, err
+		}
+	}
+	ca.MaxDuration = time.Duration(cfg.Backend.MaxDuration) * time.Hour * 24
+	ca.ClampDuration = cfg.Backend.ClampDuration
+	ca.ApexWildcards = cfg.Backend.ApexWildcards
+	ca.KeyTypes = cfg.Backend.AllowedKeyTypes
+	ca.AllowedProfiles = cfg.Backend.AllowedProfiles
+	if len(cfg.Backend.DefaultProfile) > 0 {
+		ca.DefaultProfile = cfg.Backend.DefaultProfile
+	}
+	if len(cfg.Backend.IssuerURL) > 0 {
+		ca.IssuerURLs = []string{cfg.Backend.IssuerURL}
+	}
+	if len(cfg.Backend.OCSPURL) > 0 {
+		ca.OCSPURLs = []string{cfg.Backend.OCSPURL}
+	}
+	ca.CRLURLs = cfg.Backend.CRLURLs
+	ca.Subject, err = newSubjectPolicy(cfg.Backend.Subject)
+	if err != nil {
+		log.WithError(err).Error("Unable to load the subject policy")
+		return nil, err
+	}
+	if len(cfg.Backend.Profiles) > 0 {
+		ca.Profiles, err = newProfiles(cfg.Backend.Profiles)
+		if err != nil {
+			log.WithError(err).Error("Unable to load the certificate profiles")
+			return nil, err
+		}
+	}
+
+	return ca, nil
 }
 
 func createCA(caName string,
 	cert []byte,
 	key []byte,
+	bundle string,
+	passphrase PassphraseFunc) (*ca, error) {
+
+	pemKey, _ := pem.Decode(key)
+	if pemKey == nil {
+		msg := "Unable to decode the certificate's key!"
+		log.Error(msg)
+		return nil, errors.New(msg)
+	}
+
+	caKey, err := parseCAKey(pemKey, passphrase)
+	if err != nil {
+		log.WithError(err).Error("Unable to load the CA key")
+		return nil, err
+	}
+
+	return newCA(caName, cert, caKey, bundle)
+}
+
+// newCA creates the CA from its certificate and signing key
+func newCA(caName string,
+	cert []byte,
+	caKey crypto.Signer,
 	bundle string) (*ca, error) {
 
 	if len(caName) == 0 {
@@ -93,40 +319,42 @@ func createCA(caName string,
 		return nil, errors.New(msg)
 	}
 
-	pemKey, _ := pem.Decode(key)
-	if pemKey == nil {
-		msg := "Unable to decode the certificate's key!"
-		log.Error(msg)
-		return nil, errors.New(msg)
+	caCertificate, err := x509.ParseCertificate(pemCert.Bytes)
+	if err != nil {
+		log.WithError(err).Error("error parsing CA certificate")
+		return nil, err
 	}
 
-	if x509.IsEncryptedPEMBlock(pemKey) {
-		msg := "Certificate key requires a passphrase! This is unsupported."
-		log.Error(msg)
-		return nil, errors.New(msg)
+	if err = checkKeyMatchesCertificate(caKey, caCertificate); err != nil {
+		log.WithError(err).Error("Unable to load the CA key")
+		return nil, err
 	}
-	caKey, err := x509.ParsePKCS8PrivateKey(pemKey.Bytes)
+
+	chain, root, err := buildChain(caCertificate, bundle)
 	if err != nil {
-		msg := "Unable to parse certificate's key"
-		log.WithError(err).Error(msg)
-		return nil, errors.New(msg)
+		log.WithError(err).Error("Unable to verify the CA's certificate chain")
+		return nil, err
 	}
 
-	if _, ok := caKey.(crypto.Signer); !ok {
-		msg := "hmmm, the CA private key is not a crypto.Signer"
-		log.Error(msg)
-		return nil, errors.New(msg)
-	}
+	log.Infof("permittedDomains:  %s", strings.Join(caCertificate.PermittedDNSDomains, ", "))
 
-	caCertificate, err := x509.ParseCertificate(pemCert.Bytes)
+	profiles, err := newProfiles(certMgr.DefaultProfiles)
 	if err != nil {
-		log.WithError(err).Error("error parsing CA certificate")
+		return nil, err
 	}
 
-	log.Infof("permittedDomains:  %s", strings.Join(caCertificate.PermittedDNSDomains, ", "))
+	subject, err := newSubjectPolicy(certMgr.DefaultAppConfig.Backend.Subject)
+	if err != nil {
+		return nil, err
+	}
 
 	return &ca{Name: caName,
 		SigningCertificate: *caCertificate,
-		SigningKey:         caKey.(crypto.Signer),
-		Bundle:             bundle}, nil
+		SigningKey:         caKey,
+		RootCertificate:    *root,
+		Bundle:             encodeChain(chain),
+		Profiles:           profiles,
+		DefaultProfile:     certMgr.DefaultAppConfig.Backend.DefaultProfile,
+		MaxDuration:        time.Duration(certMgr.DefaultAppConfig.Backend.MaxDuration) * time.Hour * 24,
+		Subject:            subject}, nil
 }
@@ -0,0 +1,106 @@
+package backend
+
+import (
+	"sort"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// memoryStore is a CertificateStore which forgets everything on exit.
+// It is intended for tests & development.
+type memoryStore struct {
+	sync.RWMutex
+	certs map[string]*CertificateRecord
+}
+
+// NewMemoryStore creates an empty, in-memory CertificateStore
+func NewMemoryStore() CertificateStore {
+	return &memoryStore{certs: make(map[string]*CertificateRecord)}
+}
+
+func (m *memoryStore) Insert(ctx context.Context, rec *CertificateRecord) error {
+	m.Lock()
+	defer m.Unlock()
+
+	serial := normalizeSerial(rec.Serial)
+	if _, ok := m.certs[serial]; ok {
+		return ErrCertificateExists
+	}
+	m.certs[serial] = copyRecord(rec)
+
+	return nil
+}
+
+func (m *memoryStore) Get(ctx context.Context, serial string) (*CertificateRecord, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	rec, ok := m.certs[normalizeSerial(serial)]
+	if !ok {
+		return nil, ErrCertificateNotFound
+	}
+
+	return copyRecord(rec), nil
+}
+
+func (m *memoryStore) List(ctx context.Context,
+	filter *CertificateFilter,
+	pageToken string,
+	pageSize int) ([]*CertificateRecord, string, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	pageSize = normalizePageSize(pageSize)
+
+	serials := make([]string, 0, len(m.certs))
+	for serial := range m.certs {
+		serials = append(serials, serial)
+	}
+	sort.Strings(serials)
+
+	var page []*CertificateRecord
+	start := sort.SearchStrings(serials, pageToken)
+	for _, serial := range serials[start:] {
+		if serial == pageToken {
+			continue
+		}
+
+		rec := m.certs[serial]
+		if !filter.matches(rec) {
+			continue
+		}
+
+		if len(page) == pageSize {
+			return page, normalizeSerial(page[len(page)-1].Serial), nil
+		}
+		page = append(page, copyRecord(rec))
+	}
+
+	return page, "", nil
+}
+
+func (m *memoryStore) Update(ctx context.Context,
+	serial string,
+	update func(rec *CertificateRecord) error) (*CertificateRecord, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	serial = normalizeSerial(serial)
+	rec, ok := m.certs[serial]
+	if !ok {
+		return nil, ErrCertificateNotFound
+	}
+
+	updated := copyRecord(rec)
+	if err := update(updated); err != nil {
+		return nil, err
+	}
+	m.certs[serial] = updated
+
+	return copyRecord(updated), nil
+}
+
+func (m *memoryStore) Close() error {
+	return nil
+}
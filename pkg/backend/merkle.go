@@ -0,0 +1,178 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+// Merkle tree hashing, proofs & their verification, as in RFC 6962, section 2.1.
+// Trees are given as the hashes of their leaves.
+
+var errInvalidProof = errors.New("the proof does not verify")
+
+// leafHash returns the hash of a leaf, SHA-256(0x00 || leaf)
+func leafHash(leaf []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write(leaf)
+	return h.Sum(nil)
+}
+
+// nodeHash returns the hash of an interior node, SHA-256(0x01 || left || right)
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// splitPoint returns the largest power of 2 smaller than n (n > 1)
+func splitPoint(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// merkleRoot returns the Merkle Tree Hash, MTH(D[n])
+func merkleRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		empty := sha256.Sum256(nil)
+		return empty[:]
+	case 1:
+		return leaves[0]
+	}
+
+	k := splitPoint(len(leaves))
+	return nodeHash(merkleRoot(leaves[:k]), merkleRoot(leaves[k:]))
+}
+
+// inclusionProof returns the audit path of the m'th leaf, PATH(m, D[n])
+func inclusionProof(leaves [][]byte, m int) [][]byte {
+	n := len(leaves)
+	if n <= 1 {
+		return [][]byte{}
+	}
+
+	k := splitPoint(n)
+	if m < k {
+		return append(inclusionProof(leaves[:k], m), merkleRoot(leaves[k:]))
+	}
+	return append(inclusionProof(leaves[k:], m-k), merkleRoot(leaves[:k]))
+}
+
+// consistencyProof returns the proof that the tree of the first m leaves is a
+// prefix of the tree, PROOF(m, D[n])
+func consistencyProof(leaves [][]byte, m int) [][]byte {
+	if m == 0 || m >= len(leaves) {
+		return [][]byte{}
+	}
+	return subproof(leaves, m, true)
+}
+
+// subproof is SUBPROOF(m, D[n], b)
+func subproof(leaves [][]byte, m int, complete bool) [][]byte {
+	n := len(leaves)
+	if m == n {
+		if complete {
+			return [][]byte{}
+		}
+		return [][]byte{merkleRoot(leaves)}
+	}
+
+	k := splitPoint(n)
+	if m <= k {
+		return append(subproof(leaves[:k], m, complete), merkleRoot(leaves[k:]))
+	}
+	return append(subproof(leaves[k:], m-k, false), merkleRoot(leaves[:k]))
+}
+
+// verifyInclusion checks the audit path of the leaf hash at index in the tree of
+// size leaves with the root hash (RFC 9162, section 2.1.3.2)
+func verifyInclusion(leaf []byte, index uint64, size uint64, proof [][]byte, root []byte) error {
+	if index >= size {
+		return errInvalidProof
+	}
+
+	fn, sn := index, size-1
+	r := leaf
+	for _, p := range proof {
+		if sn == 0 {
+			return errInvalidProof
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || !bytes.Equal(r, root) {
+		return errInvalidProof
+	}
+	return nil
+}
+
+// verifyConsistency checks the proof that the tree of size1 leaves with root1 is a
+// prefix of the tree of size2 leaves with root2 (RFC 9162, section 2.1.4.2)
+func verifyConsistency(size1 uint64, size2 uint64, root1 []byte, root2 []byte, proof [][]byte) error {
+	switch {
+	case size1 > size2:
+		return errInvalidProof
+	case size1 == size2:
+		if len(proof) > 0 || !bytes.Equal(root1, root2) {
+			return errInvalidProof
+		}
+		return nil
+	case size1 == 0:
+		// the empty tree is a prefix of every tree
+		return nil
+	case len(proof) == 0:
+		return errInvalidProof
+	}
+
+	// the first tree is a complete subtree of the second
+	if size1&(size1-1) == 0 {
+		proof = append([][]byte{root1}, proof...)
+	}
+
+	fn, sn := size1-1, size2-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return errInvalidProof
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || !bytes.Equal(fr, root1) || !bytes.Equal(sr, root2) {
+		return errInvalidProof
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package backend
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// the leaves of the RFC 6962 reference test vectors
+var testLeaves = []string{"", "00", "10", "2021", "3031", "40414243", "5051525354555657", "606162636465666768696a6b6c6d6e6f"}
+
+// testLeafHashes returns the hashes of n leaves: the test vectors, then distinct leaves of our own
+func testLeafHashes(t *testing.T, n int) [][]byte {
+	var hashes [][]byte
+	for i := 0; i < n; i++ {
+		leaf := []byte{0xff, byte(i)}
+		if i < len(testLeaves) {
+			var err error
+			if leaf, err = hex.DecodeString(testLeaves[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		hashes = append(hashes, leafHash(leaf))
+	}
+	return hashes
+}
+
+func TestMerkleRoot(t *testing.T) {
+	tests := map[int]string{
+		0: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		1: "6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+		8: "5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+	}
+
+	for n, want := range tests {
+		if got := hex.EncodeToString(merkleRoot(testLeafHashes(t, n))); got != want {
+			t.Errorf("MTH of %d leaves: expected %s, got %s", n, want, got)
+		}
+	}
+}
+
+func TestMerkleProofs(t *testing.T) {
+	hashes := testLeafHashes(t, 37)
+
+	for n := 1; n <= len(hashes); n++ {
+		tree := hashes[:n]
+		root := merkleRoot(tree)
+
+		for m := 0; m < n; m++ {
+			proof := inclusionProof(tree, m)
+			if err := verifyInclusion(tree[m], uint64(m), uint64(n), proof, root); err != nil {
+				t.Fatalf("inclusion of leaf %d in %d: %s", m, n, err)
+			}
+			if err := verifyInclusion(tree[(m+1)%n], uint64(m), uint64(n), proof, root); n > 1 && err == nil {
+				t.Fatalf("inclusion of the wrong leaf %d in %d verified", m, n)
+			}
+			if n > 1 {
+				proof[0] = root
+				if err := verifyInclusion(tree[m], uint64(m), uint64(n), proof, root); err == nil {
+					t.Fatalf("altered inclusion proof of leaf %d in %d verified", m, n)
+				}
+			}
+		}
+
+		for m := 0; m <= n; m++ {
+			proof := consistencyProof(tree, m)
+			if err := verifyConsistency(uint64(m), uint64(n), merkleRoot(tree[:m]), root, proof); err != nil {
+				t.Fatalf("consistency of %d with %d: %s", m, n, err)
+			}
+			if m > 0 && m < n {
+				if err := verifyConsistency(uint64(m), uint64(n), merkleRoot(tree[1:m+1]), root, proof); err == nil {
+					t.Fatalf("consistency of a different tree of %d with %d verified", m, n)
+				}
+				proof[len(proof)-1] = root
+				if err := verifyConsistency(uint64(m), uint64(n), merkleRoot(tree[:m]), root, proof); err == nil {
+					t.Fatalf("altered consistency proof of %d with %d verified", m, n)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package backend
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// nameResult is the outcome of validating one requested name
+type nameResult struct {
+	name  string // as requested
+	value string // as placed in the certificate
+	err   error  // why the name was refused, nil if it is permitted
+}
+
+// validateNames checks every requested name, returning a result for each. A name
+// must be well formed, lie within the name constraints of the signing certificate
+// and of every certificate in the bundle, and be one the caller is entitled to.
+// The first name is the certificate's subject.
+func (c *ca) validateNames(ctx context.Context, requestedHosts []string, profileName string) []nameResult {
+	constraining := c.constrainingCertificates()
+	user, groups := requester(ctx), requesterGroups(ctx)
+
+	results := make([]nameResult, len(requestedHosts))
+	for i, name := range requestedHosts {
+		value, err := normalizeName(name, i == 0)
+		for _, cert := range constraining {
+			if err != nil {
+				break
+			}
+			err = checkNameConstraints(cert, value)
+			if err == nil && !c.ApexWildcards {
+				err = checkApexWildcard(cert, value)
+			}
+		}
+
+		// wildcards are only issued to those the policy grants them
+		if _, wildcard := wildcardBase(value); err == nil && wildcard && c.Policy == nil {
+			err = policyErrorf("%s: refused, wildcards require a policy granting them", name)
+		}
+
+		// the caller must be entitled to every name
+		if err == nil && c.Policy != nil {
+			if err = c.Policy.check(user, groups, name, profileName, !c.allowsProfile(profileName)); err != nil {
+				log.WithError(err).WithField("requester", user).Warn("request refused by policy")
+			}
+		}
+
+		results[i] = nameResult{name: name, value: value, err: err}
+	}
+
+	return results
+}
+
+// normalizeName lowercases DNS names & email addresses and rejects names the CA never issues
+func normalizeName(name string, subject bool) (string, error) {
+	value := strings.ToLower(name)
+	domain := value
+
+	switch sanType(name) {
+	case sanIP:
+		if subject {
+			return name, policyErrorf("%s: refused, the subject name must not be an IP address", name)
+		}
+		return name, nil
+
+	case sanEmail:
+		// only the domain is case-insensitive; the local part is kept as requested
+		at := strings.LastIndex(name, "@")
+		domain = value[at+1:]
+		value = name[:at] + "@" + domain
+
+	case sanURI:
+		u, err := url.Parse(name)
+		if err != nil || len(u.Hostname()) == 0 {
+			return name, policyErrorf("%s: refused, not a valid URI", name)
+		}
+		value = name
+		domain = strings.ToLower(u.Hostname())
+	}
+
+	if err := checkWildcardForm(name, domain, sanType(name)); err != nil {
+		return value, err
+	}
+
+	if strings.HasPrefix(domain, "www.") {
+		return value, policyErrorf("%s: refused, www. host names are not supported", name)
+	}
+	if strings.HasPrefix(domain, ".") {
+		return value, policyErrorf("%s: refused, . host names are not supported", name)
+	}
+
+	return value, nil
+}
+
+// constrainingCertificates returns the CA certificates whose name constraints bound
+// the names this CA may issue: the signing certificate & those in the bundle
+func (c *ca) constrainingCertificates() []*x509.Certificate {
+	certs := []*x509.Certificate{&c.SigningCertificate}
+
+	rest := []byte(c.Bundle)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			log.WithError(err).Warn("Unable to parse a certificate in the CA bundle")
+			continue
+		}
+		certs = append(certs, cert)
+	}
+
+	return certs
+}
+
+// checkNameConstraints evaluates the (normalized) name against the certificate's
+// name constraints, as in RFC 5280, 4.2.1.10: the name must lie within one of the
+// permitted subtrees of its type, if there are any, and within none of the excluded
+// subtrees. Email domains & URI hosts are also bound by the DNS constraints when
+// the certificate has no constraints of their own type. A wildcard is refused if
+// any name it covers is excluded.
+func checkNameConstraints(cert *x509.Certificate, name string) error {
+	issuer := cert.Subject.CommonName
+
+	switch sanType(name) {
+	case sanIP:
+		ip := net.ParseIP(name)
+		if len(cert.PermittedIPRanges) > 0 && !ipInRanges(ip, cert.PermittedIPRanges) {
+			return policyErrorf("%s: refused, not within the IP ranges permitted by %s", name, issuer)
+		}
+		if ipInRanges(ip, cert.ExcludedIPRanges) {
+			return policyErrorf("%s: refused, within an IP range excluded by %s", name, issuer)
+		}
+		return nil
+
+	case sanEmail:
+		if len(cert.PermittedEmailAddresses) == 0 && len(cert.ExcludedEmailAddresses) == 0 {
+			return checkDNSConstraints(cert, name, name[strings.LastIndex(name, "@")+1:])
+		}
+		for _, constraint := range cert.ExcludedEmailAddresses {
+			if matchEmailConstraint(name, constraint) {
+				return policyErrorf("%s: refused, an email address excluded by %s", name, issuer)
+			}
+		}
+		if len(cert.PermittedEmailAddresses) == 0 {
+			return nil
+		}
+		for _, constraint := range cert.PermittedEmailAddresses {
+			if matchEmailConstraint(name, constraint) {
+				return nil
+			}
+		}
+		return policyErrorf("%s: refused, not an email address permitted by %s", name, issuer)
+
+	case sanURI:
+		u, _ := url.Parse(name)
+		host := strings.ToLower(u.Hostname())
+		if len(cert.PermittedURIDomains) == 0 && len(cert.ExcludedURIDomains) == 0 {
+			return checkDNSConstraints(cert, name, host)
+		}
+		if net.ParseIP(host) != nil {
+			// URI constraints are only defined for fully qualified domain names
+			return policyErrorf("%s: refused, URIs constrained by %s must name a host", name, issuer)
+		}
+		for _, constraint := range cert.ExcludedURIDomains {
+			if matchURIConstraint(host, constraint) {
+				return policyErrorf("%s: refused, a URI host excluded by %s", name, issuer)
+			}
+		}
+		if len(cert.PermittedURIDomains) == 0 {
+			return nil
+		}
+		for _, constraint := range cert.PermittedURIDomains {
+			if matchURIConstraint(host, constraint) {
+				return nil
+			}
+		}
+		return policyErrorf("%s: refused, not a URI host permitted by %s", name, issuer)
+
+	default:
+		return checkDNSConstraints(cert, name, name)
+	}
+}
+
+// checkDNSConstraints evaluates the domain of the name against the certificate's DNS constraints
+func checkDNSConstraints(cert *x509.Certificate, name string, domain string) error {
+	for _, constraint := range cert.ExcludedDNSDomains {
+		constraint = strings.ToLower(constraint)
+		if matchNameConstraint(domain, constraint) || wildcardCovers(domain, constraint) {
+			return policyErrorf("%s: refused, %s is a domain excluded by %s", name, domain, cert.Subject.CommonName)
+		}
+	}
+
+	if len(cert.PermittedDNSDomains) == 0 {
+		return nil
+	}
+	for _, constraint := range cert.PermittedDNSDomains {
+		if matchNameConstraint(domain, strings.ToLower(constraint)) {
+			return nil
+		}
+	}
+	return policyErrorf("%s: refused, %s is not a domain permitted by %s", name, domain, cert.Subject.CommonName)
+}
+
+func ipInRanges(ip net.IP, ranges []*net.IPNet) bool {
+	for _, ipNet := range ranges {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchEmailConstraint matches a mailbox against an RFC 5280 email constraint: a
+// complete mailbox, a host (any mailbox on it), or a domain with a leading dot
+// (any mailbox on a host within it)
+func matchEmailConstraint(mailbox, constraint string) bool {
+	at := strings.LastIndex(mailbox, "@")
+	local, domain := mailbox[:at], strings.ToLower(mailbox[at+1:])
+
+	if i := strings.LastIndex(constraint, "@"); i >= 0 {
+		return local == constraint[:i] && domain == strings.ToLower(constraint[i+1:])
+	}
+	return matchURIConstraint(domain, constraint)
+}
+
+// matchURIConstraint matches a host against an RFC 5280 URI constraint: the host
+// itself, or a domain with a leading dot (any host within it)
+func matchURIConstraint(host, constraint string) bool {
+	constraint = strings.ToLower(constraint)
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(host, constraint)
+	}
+	return host == constraint
+}
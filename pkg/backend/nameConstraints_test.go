@@ -0,0 +1,131 @@
+package backend
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func mustParseCIDR(t *testing.T, cidr string) *net.IPNet {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ipNet
+}
+
+// newConstrainedCA returns a CA constrained by its signing certificate & by an
+// intermediate in its bundle
+func newConstrainedCA(t *testing.T) *ca {
+	c := newTestCA(t)
+
+	signing := &c.SigningCertificate
+	signing.ExcludedDNSDomains = []string{"secret.dstcorp.io"}
+	signing.PermittedIPRanges = []*net.IPNet{mustParseCIDR(t, "10.0.0.0/8")}
+	signing.ExcludedIPRanges = []*net.IPNet{mustParseCIDR(t, "10.66.0.0/16")}
+	signing.PermittedEmailAddresses = []string{".dstcorp.io", "dstcorp.io"}
+	signing.ExcludedEmailAddresses = []string{"root@dstcorp.io", "Admin@dstcorp.io"}
+	signing.PermittedURIDomains = []string{".dstcorp.io"}
+
+	intermediate := issueTestCACertificate(t, &x509.Certificate{
+		Subject:            pkix.Name{CommonName: "intermediate.dstcorp.io"},
+		NotAfter:           time.Now().Add(time.Hour),
+		ExcludedDNSDomains: []string{"legacy.dstcorp.io"},
+	}, nil, nil)
+	c.Bundle = intermediate.pem()
+
+	return c
+}
+
+func TestNameConstraints(t *testing.T) {
+	c := newConstrainedCA(t)
+
+	tests := []struct {
+		name    string
+		refusal string // substring of the expected refusal, empty if permitted
+	}{
+		{"foo.dstcorp.io", ""},
+		{"FOO.dstcorp.io", ""},
+		{"foo.example.com", "not a domain permitted by test-ca.dstcorp.io"},
+		{"secret.dstcorp.io", "excluded by test-ca.dstcorp.io"},
+		{"db.secret.dstcorp.io", "excluded by test-ca.dstcorp.io"},
+		{"db.legacy.dstcorp.io", "excluded by intermediate.dstcorp.io"},
+		{"www.dstcorp.io", "www. host names are not supported"},
+		{"10.1.2.3", ""},
+		{"10.66.1.1", "within an IP range excluded"},
+		{"192.168.1.1", "not within the IP ranges permitted"},
+		{"::1", "not within the IP ranges permitted"},
+		{"bob@db.dstcorp.io", ""},
+		{"bob@dstcorp.io", ""},
+		{"root@dstcorp.io", "an email address excluded"},
+		{"root@DSTCORP.io", "an email address excluded"},
+		{"Root@dstcorp.io", ""},
+		{"Admin@dstcorp.io", "an email address excluded"},
+		{"admin@dstcorp.io", ""},
+		{"bob@example.com", "not an email address permitted"},
+		{"spiffe://svc.dstcorp.io/db", ""},
+		{"spiffe://dstcorp.io/db", "not a URI host permitted"},
+		{"https://10.1.2.3/", "must name a host"},
+	}
+
+	// the local part of a mailbox is case-sensitive
+	if value, err := normalizeName("Bob@DB.dstcorp.io", false); err != nil || value != "Bob@db.dstcorp.io" {
+		t.Errorf("expected Bob@db.dstcorp.io, got %s (%v)", value, err)
+	}
+
+	for _, test := range tests {
+		// the subject name is a DNS name, so each name is checked as an alternate name
+		results := c.validateNames(context.Background(), []string{"subject.dstcorp.io", test.name}, "server")
+		err := results[1].err
+		switch {
+		case len(test.refusal) == 0 && err != nil:
+			t.Errorf("%s: unexpected refusal: %s", test.name, err)
+		case len(test.refusal) > 0 && err == nil:
+			t.Errorf("%s: expected a refusal", test.name)
+		case err != nil && !strings.Contains(err.Error(), test.refusal):
+			t.Errorf("%s: expected %q, got %q", test.name, test.refusal, err)
+		}
+	}
+}
+
+func TestValidateRequestChecksEveryName(t *testing.T) {
+	c := newTestCA(t)
+	ctx := context.Background()
+	prof, err := c.lookupProfile("server")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// neither an earlier permitted name nor an IP address lets later names through
+	for _, names := range [][]string{
+		{"foo.dstcorp.io", "bar.example.com"},
+		{"foo.dstcorp.io", "10.1.2.3", "bar.example.com"},
+	} {
+		if _, err := c.validateRequest(ctx, names, 0, prof); err == nil || !strings.Contains(err.Error(), "bar.example.com") {
+			t.Errorf("%v: expected bar.example.com to be refused, got %v", names, err)
+		}
+	}
+
+	// every refused name is reported
+	_, err = c.validateRequest(ctx, []string{"foo.dstcorp.io", "a.example.com", "b.example.com"}, 0, prof)
+	if err == nil || !strings.Contains(err.Error(), "a.example.com") || !strings.Contains(err.Error(), "b.example.com") {
+		t.Errorf("expected both names to be refused, got %v", err)
+	}
+
+	if _, err = c.validateRequest(ctx, []string{"10.1.2.3"}, 0, prof); err == nil {
+		t.Error("expected an IP address subject name to be refused")
+	}
+
+	hosts, err := c.validateRequest(ctx, []string{"Foo.dstcorp.io", "10.1.2.3"}, 0, prof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hosts[0] != "foo.dstcorp.io" || hosts[1] != "10.1.2.3" {
+		t.Errorf("unexpected names %v", hosts)
+	}
+}
@@ -0,0 +1,285 @@
+package backend
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mchudgins/certMgr/pkg/certMgr"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ocsp"
+	"golang.org/x/net/context"
+)
+
+const (
+	ocspSigningProfile = "ocsp-signing"
+	maxOCSPRequestSize = 10 * 1024
+)
+
+var (
+	// id-pkix-ocsp-nocheck, RFC 6960 section 4.2.2.2.1
+	oidOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+	asn1Null       = []byte{0x05, 0x00}
+)
+
+// ocspCacheEntry is a signed response which remains valid until nextUpdate
+type ocspCacheEntry struct {
+	der        []byte
+	thisUpdate time.Time
+	nextUpdate time.Time
+}
+
+// ocspResponder answers RFC 6960 requests from the certificate store.
+// Responses are signed by a delegated OCSP signing certificate which the
+// CA issues to the responder, and are cached until their nextUpdate.
+type ocspResponder struct {
+	sync.Mutex
+	ca       *ca
+	store    CertificateStore
+	validity time.Duration // interval between thisUpdate & nextUpdate
+
+	signer crypto.Signer     // the delegated responder's key
+	cert   *x509.Certificate // the delegated responder's certificate
+	cache  map[string]*ocspCacheEntry
+}
+
+func newOCSPResponder(c *ca, store CertificateStore, validity time.Duration) *ocspResponder {
+	return &ocspResponder{
+		ca:       c,
+		store:    store,
+		validity: validity,
+		cache:    make(map[string]*ocspCacheEntry),
+	}
+}
+
+// responderCertificate returns the delegated signing certificate & key,
+// issuing a new certificate when the current one is past its half-life.
+// The caller must hold the lock.
+func (o *ocspResponder) responderCertificate(ctx context.Context) (*x509.Certificate, crypto.Signer, error) {
+	now := time.Now()
+	if o.cert != nil && now.Before(o.cert.NotBefore.Add(o.cert.NotAfter.Sub(o.cert.NotBefore)/2)) {
+		return o.cert, o.signer, nil
+	}
+
+	prof, ok := o.ca.Profiles[ocspSigningProfile]
+	if !ok {
+		var err error
+		prof, err = newProfile(ocspSigningProfile, certMgr.DefaultProfiles[ocspSigningProfile])
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	duration := prof.MaxDuration
+	if duration == 0 {
+		duration = 30 * 24 * time.Hour
+	}
+
+	key, err := generateKey(DefaultKeyType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := newTemplate(pkix.Name{
+		CommonName:   o.ca.Name + " OCSP Responder",
+		Organization: o.ca.SigningCertificate.Subject.Organization,
+	}, nil, duration, prof)
+	template.ExtraExtensions = append(template.ExtraExtensions,
+		pkix.Extension{Id: oidOCSPNoCheck, Value: asn1Null})
+
+	certPEM, err := o.ca.sign(ctx, template, key.Public(), prof, map[string]string{"purpose": "ocsp-responder"})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, _ := pem.Decode([]byte(certPEM))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.WithFields(log.Fields{"ca": o.ca.Name, "serial": SerialString(cert.SerialNumber), "notAfter": cert.NotAfter}).
+		Info("issued OCSP responder certificate")
+
+	// responses signed by the previous certificate are discarded
+	o.cert, o.signer = cert, key
+	o.cache = make(map[string]*ocspCacheEntry)
+
+	return o.cert, o.signer, nil
+}
+
+// invalidate discards any cached response for the serial number
+func (o *ocspResponder) invalidate(serial string) {
+	o.Lock()
+	defer o.Unlock()
+
+	delete(o.cache, normalizeSerial(serial))
+}
+
+// issuedByCA verifies the request's issuer name & key hashes identify the CA
+func (o *ocspResponder) issuedByCA(req *ocsp.Request) bool {
+	if !req.HashAlgorithm.Available() {
+		return false
+	}
+
+	keyBits, err := subjectPublicKeyBits(o.ca.SigningCertificate.RawSubjectPublicKeyInfo)
+	if err != nil {
+		return false
+	}
+
+	h := req.HashAlgorithm.New()
+	h.Write(o.ca.SigningCertificate.RawSubject)
+	nameHash := h.Sum(nil)
+
+	h.Reset()
+	h.Write(keyBits)
+	keyHash := h.Sum(nil)
+
+	return bytes.Equal(nameHash, req.IssuerNameHash) && bytes.Equal(keyHash, req.IssuerKeyHash)
+}
+
+// respond returns the signed response to the request, from the cache when possible
+func (o *ocspResponder) respond(ctx context.Context, req *ocsp.Request) (*ocspCacheEntry, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	serial := SerialString(req.SerialNumber)
+	now := time.Now()
+
+	responderCert, signer, err := o.responderCertificate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry, ok := o.cache[serial]; ok && now.Before(entry.nextUpdate) {
+		return entry, nil
+	}
+
+	template := ocsp.Response{
+		SerialNumber: new(big.Int).Set(req.SerialNumber),
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(o.validity),
+		Certificate:  responderCert,
+	}
+
+	rec, err := o.store.Get(ctx, serial)
+	switch {
+	case err == ErrCertificateNotFound || (err == nil && rec.CA != o.ca.Name):
+		template.Status = ocsp.Unknown
+	case err != nil:
+		return nil, err
+	case rec.Revoked:
+		template.Status = ocsp.Revoked
+		template.RevokedAt = rec.RevokedAt
+		template.RevocationReason = rec.RevocationReason
+	default:
+		template.Status = ocsp.Good
+	}
+
+	der, err := ocsp.CreateResponse(&o.ca.SigningCertificate, responderCert, template, signer)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &ocspCacheEntry{der: der, thisUpdate: template.ThisUpdate, nextUpdate: template.NextUpdate}
+	o.cache[serial] = entry
+
+	return entry, nil
+}
+
+// ServeHTTP answers OCSP requests made via GET (RFC 6960 appendix A.1) or POST
+func (o *ocspResponder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if req := parseOCSPRequest(w, r); req != nil {
+		o.serve(w, r, req)
+	}
+}
+
+// ocspMux answers OCSP requests for several CAs, each with the responder of the
+// CA which issued the certificate in question
+type ocspMux []*ocspResponder
+
+// ServeHTTP answers OCSP requests made via GET (RFC 6960 appendix A.1) or POST
+func (m ocspMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := parseOCSPRequest(w, r)
+	if req == nil {
+		return
+	}
+
+	for _, o := range m {
+		if o.issuedByCA(req) {
+			o.serve(w, r, req)
+			return
+		}
+	}
+	w.Write(ocsp.UnauthorizedErrorResponse)
+}
+
+// parseOCSPRequest decodes the request, answering a malformed one itself (and
+// returning nil)
+func parseOCSPRequest(w http.ResponseWriter, r *http.Request) *ocsp.Request {
+	var body []byte
+	var err error
+
+	switch r.Method {
+	case "GET":
+		encoded := strings.TrimPrefix(r.URL.Path, "/")
+		body, err = base64.StdEncoding.DecodeString(encoded)
+	case "POST":
+		body, err = ioutil.ReadAll(io.LimitReader(r.Body, maxOCSPRequestSize))
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "application/ocsp-response")
+
+	var req *ocsp.Request
+	if err == nil {
+		req, err = ocsp.ParseRequest(body)
+	}
+	if err != nil {
+		log.WithError(err).Debug("malformed OCSP request")
+		w.Write(ocsp.MalformedRequestErrorResponse)
+		return nil
+	}
+
+	return req
+}
+
+// serve answers the parsed request
+func (o *ocspResponder) serve(w http.ResponseWriter, r *http.Request, req *ocsp.Request) {
+	if !o.issuedByCA(req) {
+		w.Write(ocsp.UnauthorizedErrorResponse)
+		return
+	}
+
+	entry, err := o.respond(r.Context(), req)
+	if err != nil {
+		log.WithError(err).WithField("serial", SerialString(req.SerialNumber)).Error("Unable to create OCSP response")
+		w.Write(ocsp.InternalErrorErrorResponse)
+		return
+	}
+
+	// RFC 5019 caching headers
+	maxAge := int(entry.nextUpdate.Sub(time.Now()).Seconds())
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, public, no-transform, must-revalidate", maxAge))
+	w.Header().Set("Last-Modified", entry.thisUpdate.UTC().Format(http.TimeFormat))
+	w.Header().Set("Expires", entry.nextUpdate.UTC().Format(http.TimeFormat))
+	w.Write(entry.der)
+}
@@ -0,0 +1,137 @@
+package backend
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+	"golang.org/x/net/context"
+)
+
+func TestOCSPResponder(t *testing.T) {
+	ctx := context.Background()
+	c := newTestCA(t)
+	c.OCSP = newOCSPResponder(c, c.Store, time.Hour)
+
+	srv := httptest.NewServer(c.OCSP)
+	defer srv.Close()
+
+	issue := func(cn string) *x509.Certificate {
+		certPEM, _, err := c.CreateCertificate(ctx, cn, []string{cn}, 24*time.Hour, "", "", pkix.Name{}, nil)
+		if err != nil {
+			t.Fatalf("CreateCertificate: %s", err)
+		}
+		block, _ := pem.Decode([]byte(certPEM))
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+
+	query := func(cert *x509.Certificate, post bool) *ocsp.Response {
+		req, err := ocsp.CreateRequest(cert, &c.SigningCertificate, &ocsp.RequestOptions{Hash: crypto.SHA1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var resp *http.Response
+		if post {
+			resp, err = http.Post(srv.URL, "application/ocsp-request", bytes.NewReader(req))
+		} else {
+			resp, err = http.Get(srv.URL + "/" + base64.StdEncoding.EncodeToString(req))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if ct := resp.Header.Get("Content-Type"); ct != "application/ocsp-response" {
+			t.Errorf("expected application/ocsp-response, got %s", ct)
+		}
+
+		der, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parsed, err := ocsp.ParseResponseForCert(der, cert, &c.SigningCertificate)
+		if err != nil {
+			t.Fatalf("ParseResponse: %s", err)
+		}
+		return parsed
+	}
+
+	good := issue("good.dstcorp.io")
+	revoked := issue("revoked.dstcorp.io")
+
+	resp := query(good, false)
+	if resp.Status != ocsp.Good {
+		t.Errorf("expected Good, got %d", resp.Status)
+	}
+	if resp.Certificate == nil || resp.Certificate.Equal(&c.SigningCertificate) {
+		t.Fatal("expected the response to be signed by a delegated responder certificate")
+	}
+	if len(resp.Certificate.ExtKeyUsage) != 1 || resp.Certificate.ExtKeyUsage[0] != x509.ExtKeyUsageOCSPSigning {
+		t.Errorf("responder certificate lacks the OCSP signing EKU: %v", resp.Certificate.ExtKeyUsage)
+	}
+	if resp.NextUpdate.Sub(resp.ThisUpdate) != time.Hour {
+		t.Errorf("expected an hour between thisUpdate & nextUpdate, got %s", resp.NextUpdate.Sub(resp.ThisUpdate))
+	}
+
+	// the cached response is returned until nextUpdate
+	if cached := query(good, true); !cached.ThisUpdate.Equal(resp.ThisUpdate) {
+		t.Error("expected the cached response")
+	}
+
+	// prime the cache, then revoke
+	query(revoked, true)
+	if _, err := c.Revoke(ctx, SerialString(revoked.SerialNumber), ReasonKeyCompromise); err != nil {
+		t.Fatal(err)
+	}
+	resp = query(revoked, true)
+	if resp.Status != ocsp.Revoked {
+		t.Errorf("expected Revoked, got %d", resp.Status)
+	}
+	if resp.RevocationReason != ReasonKeyCompromise {
+		t.Errorf("expected reason %d, got %d", ReasonKeyCompromise, resp.RevocationReason)
+	}
+
+	// a serial number the CA never issued
+	unknown := *good
+	unknown.SerialNumber = big.NewInt(42)
+	if resp = query(&unknown, false); resp.Status != ocsp.Unknown {
+		t.Errorf("expected Unknown, got %d", resp.Status)
+	}
+
+	// only one responder certificate is issued
+	records, _, err := c.Store.List(ctx, &CertificateFilter{Labels: map[string]string{"purpose": "ocsp-responder"}}, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Errorf("expected 1 responder certificate in the store, found %d", len(records))
+	}
+}
+
+func TestOCSPMalformedRequest(t *testing.T) {
+	c := newTestCA(t)
+	c.OCSP = newOCSPResponder(c, c.Store, time.Hour)
+
+	w := httptest.NewRecorder()
+	c.OCSP.ServeHTTP(w, httptest.NewRequest("POST", "/", bytes.NewReader([]byte("junk"))))
+
+	if !bytes.Equal(w.Body.Bytes(), ocsp.MalformedRequestErrorResponse) {
+		t.Errorf("expected a malformedRequest response, got %x", w.Body.Bytes())
+	}
+}
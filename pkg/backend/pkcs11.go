@@ -0,0 +1,53 @@
+//go:build cgo
+
+package backend
+
+import (
+	"fmt"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/mchudgins/certMgr/pkg/certMgr"
+	log "github.com/sirupsen/logrus"
+)
+
+// loadPKCS11Signer logs in to the token and finds the CA's private key.
+// The key never leaves the token; the token is asked to sign each certificate.
+func loadPKCS11Signer(cfg certMgr.PKCS11Config) (*tokenSigner, error) {
+	if len(cfg.TokenLabel) == 0 || len(cfg.KeyLabel) == 0 {
+		return nil, fmt.Errorf("the PKCS#11 token label and key label are required")
+	}
+
+	pin, err := pinFromConfig(cfg)()
+	if err == ErrPassphraseRequired {
+		return nil, fmt.Errorf("no PIN was provided for the PKCS#11 token %s", cfg.TokenLabel)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	logger := log.WithFields(log.Fields{"module": cfg.Module, "token": cfg.TokenLabel, "key": cfg.KeyLabel})
+
+	ctx, err := crypto11.Configure(&crypto11.Config{
+		Path:       cfg.Module,
+		TokenLabel: cfg.TokenLabel,
+		Pin:        string(pin),
+	})
+	if err != nil {
+		logger.WithError(err).Error("Unable to open the PKCS#11 token")
+		return nil, fmt.Errorf("Unable to open the PKCS#11 token %s: %s", cfg.TokenLabel, err)
+	}
+
+	key, err := ctx.FindKeyPair(nil, []byte(cfg.KeyLabel))
+	if err == nil && key == nil {
+		err = fmt.Errorf("no key pair labelled %s", cfg.KeyLabel)
+	}
+	if err != nil {
+		ctx.Close()
+		logger.WithError(err).Error("Unable to find the CA key in the PKCS#11 token")
+		return nil, fmt.Errorf("Unable to find the CA key in the PKCS#11 token %s: %s", cfg.TokenLabel, err)
+	}
+
+	logger.Info("using the CA key held in the PKCS#11 token")
+
+	return &tokenSigner{Signer: key, token: cfg.TokenLabel, closer: ctx}, nil
+}
@@ -0,0 +1,14 @@
+//go:build !cgo
+
+package backend
+
+import (
+	"errors"
+
+	"github.com/mchudgins/certMgr/pkg/certMgr"
+)
+
+// loadPKCS11Signer is unavailable: loading a PKCS#11 module requires cgo
+func loadPKCS11Signer(cfg certMgr.PKCS11Config) (*tokenSigner, error) {
+	return nil, errors.New("PKCS#11 tokens are not supported by this build of certMgr (it requires cgo)")
+}
@@ -0,0 +1,131 @@
+//go:build cgo
+
+package backend
+
+import (
+	"crypto/elliptic"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/mchudgins/certMgr/pkg/certMgr"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// softHSMModule locates the SoftHSM2 PKCS#11 module, preferring $SOFTHSM2_MODULE
+func softHSMModule() string {
+	if module := os.Getenv("SOFTHSM2_MODULE"); len(module) > 0 {
+		return module
+	}
+	for _, module := range []string{
+		"/usr/lib/softhsm/libsofthsm2.so",
+		"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+		"/usr/lib64/pkcs11/libsofthsm2.so",
+		"/usr/local/lib/softhsm/libsofthsm2.so",
+	} {
+		if _, err := os.Stat(module); err == nil {
+			return module
+		}
+	}
+	return ""
+}
+
+// TestPKCS11Signer issues certificates with a CA key held in a SoftHSM2 token
+func TestPKCS11Signer(t *testing.T) {
+	module := softHSMModule()
+	util, err := exec.LookPath("softhsm2-util")
+	if len(module) == 0 || err != nil {
+		t.Skip("SoftHSM2 is not installed (set SOFTHSM2_MODULE to its PKCS#11 module)")
+	}
+
+	dir, err := ioutil.TempDir("", "certMgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a private token directory, so the test leaves the system's tokens alone
+	tokens := filepath.Join(dir, "tokens")
+	if err = os.Mkdir(tokens, 0700); err != nil {
+		t.Fatal(err)
+	}
+	conf := filepath.Join(dir, "softhsm2.conf")
+	err = ioutil.WriteFile(conf, []byte(fmt.Sprintf("directories.tokendir = %s\nobjectstore.backend = file\n", tokens)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("SOFTHSM2_CONF", conf)
+	defer os.Unsetenv("SOFTHSM2_CONF")
+
+	out, err := exec.Command(util, "--init-token", "--free", "--label", "certmgr-test",
+		"--pin", "1234", "--so-pin", "5678").CombinedOutput()
+	if err != nil {
+		t.Fatalf("softhsm2-util: %s: %s", err, out)
+	}
+
+	// generate the CA key in the token & self-sign the CA certificate with it
+	p11, err := crypto11.Configure(&crypto11.Config{Path: module, TokenLabel: "certmgr-test", Pin: "1234"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := p11.GenerateECDSAKeyPairWithLabel([]byte("ca"), []byte("ca-key"), elliptic.P256())
+	if err != nil {
+		p11.Close()
+		t.Fatal(err)
+	}
+	caCert := []byte(newTestCACertificate(t, "test-ca.dstcorp.io", key, nil, time.Now().Add(24*time.Hour)).pem())
+	p11.Close()
+
+	cfg := certMgr.PKCS11Config{Module: module, TokenLabel: "certmgr-test", KeyLabel: "ca-key", PinEnv: "CERTMGR_TEST_PIN"}
+
+	if _, err = loadPKCS11Signer(cfg); err == nil {
+		t.Error("expected the token to require a PIN")
+	}
+	os.Setenv("CERTMGR_TEST_PIN", "0000")
+	defer os.Unsetenv("CERTMGR_TEST_PIN")
+	if _, err = loadPKCS11Signer(cfg); err == nil {
+		t.Error("expected the wrong PIN to be refused")
+	}
+	os.Setenv("CERTMGR_TEST_PIN", "1234")
+	missing := cfg
+	missing.KeyLabel = "no-such-key"
+	if _, err = loadPKCS11Signer(missing); err == nil {
+		t.Error("expected the missing key to be reported")
+	}
+
+	signer, err := loadPKCS11Signer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newCA("test", caCert, signer, "")
+	if err != nil {
+		signer.Close()
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	cert, _, err := c.CreateCertificate(ctx, "foo.dstcorp.io", []string{"foo.dstcorp.io"},
+		24*time.Hour, "", "", pkix.Name{}, nil)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %s", err)
+	}
+	if err = parseTestCertificate(t, cert).CheckSignatureFrom(&c.SigningCertificate); err != nil {
+		t.Errorf("the certificate was not signed by the token: %s", err)
+	}
+
+	// once the token is gone, requests fail cleanly
+	signer.Close()
+	_, _, err = c.CreateCertificate(ctx, "foo.dstcorp.io", []string{"foo.dstcorp.io"},
+		24*time.Hour, "", "", pkix.Name{}, nil)
+	if status.Code(statusError(err)) != codes.Unavailable {
+		t.Errorf("expected Unavailable, got %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package backend
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/mchudgins/certMgr/pkg/certMgr"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// domainPolicy restricts the names & profiles each user or group may request
+type domainPolicy struct {
+	rules []*policyRule
+}
+
+type policyRule struct {
+	users    []string
+	groups   []string
+	domains  []string
+	ipRanges []*net.IPNet
+	profiles []string
+
+	wildcards bool
+}
+
+// loadDomainPolicy reads the policy file (YAML or JSON, by its extension)
+func loadDomainPolicy(filename string) (*domainPolicy, error) {
+	v := viper.New()
+	v.SetConfigFile(filename)
+	if err := v.ReadInConfig(); err != nil {
+		log.WithError(err).WithField("file", filename).Error("Unable to read the policy file")
+		return nil, err
+	}
+
+	var cfg certMgr.PolicyConfig
+	if err := v.Unmarshal(&cfg); err != nil {
+		log.WithError(err).WithField("file", filename).Error("Unable to parse the policy file")
+		return nil, err
+	}
+
+	p, err := newDomainPolicy(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	log.WithFields(log.Fields{"file": filename, "rules": len(p.rules)}).Info("policy loaded")
+
+	return p, nil
+}
+
+func newDomainPolicy(cfg certMgr.PolicyConfig) (*domainPolicy, error) {
+	p := &domainPolicy{}
+
+	for i, rc := range cfg.Rules {
+		r := &policyRule{
+			domains:   lowerAll(rc.Domains),
+			profiles:  lowerAll(rc.Profiles),
+			wildcards: rc.Wildcards,
+		}
+
+		for _, u := range lowerAll(rc.Users) {
+			if _, err := path.Match(u, ""); err != nil {
+				return nil, fmt.Errorf("rule %d: invalid user pattern %s", i+1, u)
+			}
+			r.users = append(r.users, u)
+		}
+		for _, g := range lowerAll(rc.Groups) {
+			if _, err := path.Match(g, ""); err != nil {
+				return nil, fmt.Errorf("rule %d: invalid group pattern %s", i+1, g)
+			}
+			r.groups = append(r.groups, g)
+		}
+
+		for _, cidr := range rc.IPRanges {
+			_, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: invalid IP range %s", i+1, cidr)
+			}
+			r.ipRanges = append(r.ipRanges, ipNet)
+		}
+
+		p.rules = append(p.rules, r)
+	}
+
+	return p, nil
+}
+
+// rulesFor returns the rules applying to the user or any of the user's groups
+func (p *domainPolicy) rulesFor(user string, groups []string) []*policyRule {
+	var rules []*policyRule
+
+	for _, r := range p.rules {
+		if len(user) > 0 && matchesAny(r.users, user) {
+			rules = append(rules, r)
+			continue
+		}
+		for _, g := range groups {
+			if matchesAny(r.groups, g) {
+				rules = append(rules, r)
+				break
+			}
+		}
+	}
+
+	return rules
+}
+
+// check returns a policy error explaining why the user may not request
+// the name with the profile, or nil if a rule entitles them to it. A profile
+// the CA does not allow must be named by the rule.
+func (p *domainPolicy) check(user string, groups []string, name string, profileName string, explicit bool) error {
+	rules := p.rulesFor(user, groups)
+	if len(rules) == 0 {
+		return policyErrorf("%s: refused, no policy rule applies to %s", name, displayUser(user))
+	}
+
+	_, wildcard := wildcardBase(strings.ToLower(name))
+
+	nameEntitled, wildcardEntitled := false, false
+	for _, r := range rules {
+		if !r.permitsName(name) {
+			continue
+		}
+		wildcardEntitled = true
+		if wildcard && !r.wildcards {
+			continue
+		}
+		if r.permitsProfile(profileName, explicit) {
+			return nil
+		}
+		nameEntitled = true
+	}
+
+	if nameEntitled {
+		return policyErrorf("%s: refused, %s may not request it with the %s profile",
+			name, displayUser(user), profileName)
+	}
+
+	if wildcardEntitled && wildcard {
+		return policyErrorf("%s: refused, %s may not request wildcards for this domain", name, displayUser(user))
+	}
+
+	return policyErrorf("%s: refused, %s is not entitled to this name", name, displayUser(user))
+}
+
+// permitsName returns true if the name lies within the rule's domains or IP ranges.
+// Email addresses & URIs are matched by their domain.
+func (r *policyRule) permitsName(name string) bool {
+	if ip := net.ParseIP(name); ip != nil {
+		for _, ipNet := range r.ipRanges {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	domain := strings.ToLower(name)
+	switch sanType(name) {
+	case sanEmail:
+		domain = domain[strings.LastIndex(domain, "@")+1:]
+	case sanURI:
+		u, err := url.Parse(name)
+		if err != nil {
+			return false
+		}
+		domain = strings.ToLower(u.Hostname())
+	}
+
+	for _, constraint := range r.domains {
+		if matchNameConstraint(domain, constraint) {
+			return true
+		}
+	}
+	return false
+}
+
+// grantsProfile returns true if any rule names the profile
+func (p *domainPolicy) grantsProfile(profileName string) bool {
+	for _, r := range p.rules {
+		if contains(r.profiles, profileName) {
+			return true
+		}
+	}
+	return false
+}
+
+// permitsProfile returns true if the rule names the profile or, unless it must
+// be named explicitly, lists no profiles
+func (r *policyRule) permitsProfile(profileName string, explicit bool) bool {
+	return contains(r.profiles, profileName) || (!explicit && len(r.profiles) == 0)
+}
+
+func lowerAll(values []string) []string {
+	var lowered []string
+	for _, v := range values {
+		if v = strings.ToLower(strings.TrimSpace(v)); len(v) > 0 {
+			lowered = append(lowered, v)
+		}
+	}
+	return lowered
+}
+
+func displayUser(user string) string {
+	if len(user) == 0 {
+		return "an anonymous caller"
+	}
+	return user
+}
@@ -0,0 +1,181 @@
+package backend
+
+import (
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/mchudgins/certMgr/pkg/service"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const testPolicy = `
+rules:
+  - users: ["alice@dstcorp.com"]
+    domains: ["web.dstcorp.io"]
+    profiles: ["server"]
+  - groups: ["sre"]
+    domains: [".svc.dstcorp.io"]
+    ipRanges: ["10.1.0.0/16"]
+  - users: ["*@dstcorp.com"]
+    domains: ["sandbox.dstcorp.io"]
+    profiles: ["mtls"]
+  - groups: ["release"]
+    domains: ["build.dstcorp.io"]
+    profiles: ["code-signing"]
+`
+
+func loadTestPolicy(t *testing.T) *domainPolicy {
+	dir, err := ioutil.TempDir("", "certMgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "policy.yaml")
+	if err = ioutil.WriteFile(filename, []byte(testPolicy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := loadDomainPolicy(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestDomainPolicy(t *testing.T) {
+	p := loadTestPolicy(t)
+
+	tests := []struct {
+		user     string
+		groups   []string
+		name     string
+		profile  string
+		explicit bool   // the profile must be named by the rule
+		refusal  string // substring of the expected refusal, empty if permitted
+	}{
+		{"alice@dstcorp.com", nil, "web.dstcorp.io", "server", false, ""},
+		{"alice@dstcorp.com", nil, "api.web.dstcorp.io", "server", false, ""},
+		{"alice@dstcorp.com", nil, "web.dstcorp.io", "mtls", false, "may not request it with the mtls profile"},
+		{"alice@dstcorp.com", nil, "payroll.dstcorp.io", "server", false, "not entitled to this name"},
+		{"alice@dstcorp.com", nil, "sandbox.dstcorp.io", "mtls", false, ""},
+		{"bob@dstcorp.com", []string{"sre"}, "db.svc.dstcorp.io", "client", false, ""},
+		{"bob@dstcorp.com", []string{"sre"}, "svc.dstcorp.io", "client", false, "not entitled"},
+		{"bob@dstcorp.com", []string{"sre"}, "10.1.2.3", "server", false, ""},
+		{"bob@dstcorp.com", []string{"sre"}, "10.2.0.1", "server", false, "not entitled"},
+		{"bob@dstcorp.com", []string{"sre"}, "bob@db.svc.dstcorp.io", "email", false, ""},
+		{"mallory@example.com", nil, "web.dstcorp.io", "server", false, "no policy rule applies to mallory@example.com"},
+		{"", nil, "web.dstcorp.io", "server", false, "no policy rule applies"},
+		{"bob@dstcorp.com", []string{"sre"}, "db.svc.dstcorp.io", "code-signing", true, "may not request it with the code-signing profile"},
+		{"carol@dstcorp.com", []string{"release"}, "build.dstcorp.io", "code-signing", true, ""},
+	}
+
+	for _, test := range tests {
+		err := p.check(test.user, test.groups, test.name, test.profile, test.explicit)
+		switch {
+		case len(test.refusal) == 0 && err != nil:
+			t.Errorf("%s %s/%s: unexpected refusal: %s", test.user, test.name, test.profile, err)
+		case len(test.refusal) > 0 && err == nil:
+			t.Errorf("%s %s/%s: expected a refusal", test.user, test.name, test.profile)
+		case err != nil && !strings.Contains(err.Error(), test.refusal):
+			t.Errorf("%s %s/%s: expected %q, got %q", test.user, test.name, test.profile, test.refusal, err)
+		case err != nil && !strings.HasPrefix(err.Error(), test.name+":"):
+			t.Errorf("%s: the refusal should name the refused name: %s", test.name, err)
+		}
+	}
+
+	if !p.grantsProfile("code-signing") || p.grantsProfile("client") {
+		t.Error("expected only the profiles named by a rule to be granted")
+	}
+}
+
+func TestPolicyEnforcement(t *testing.T) {
+	c := newTestCA(t)
+	c.Policy = loadTestPolicy(t)
+	ctx := metadata.NewContext(context.Background(), metadata.Pairs(remoteUserMetadataKey, "alice@dstcorp.com"))
+
+	_, _, err := c.CreateCertificate(ctx, "web.dstcorp.io", []string{"web.dstcorp.io"},
+		24*time.Hour, "", "server", pkix.Name{}, nil)
+	if err != nil {
+		t.Errorf("expected web.dstcorp.io to be issued: %s", err)
+	}
+
+	_, _, err = c.CreateCertificate(ctx, "web.dstcorp.io", []string{"web.dstcorp.io", "payroll.dstcorp.io"},
+		24*time.Hour, "", "server", pkix.Name{}, nil)
+	if status.Code(statusError(err)) != codes.InvalidArgument || !strings.Contains(err.Error(), "payroll.dstcorp.io") {
+		t.Errorf("expected payroll.dstcorp.io to be refused, got %v", err)
+	}
+
+	// dry-run
+	reply := c.checkEntitlements(ctx, []string{"web.dstcorp.io", "payroll.dstcorp.io"}, "server")
+	if reply.Permitted || len(reply.Reason) == 0 {
+		t.Errorf("expected the dry-run to be refused: %+v", reply)
+	}
+	if len(reply.Names) != 2 || !reply.Names[0].Permitted || reply.Names[1].Permitted {
+		t.Errorf("unexpected per-name results: %+v", reply.Names)
+	}
+	if len(reply.Entitlements) != 2 {
+		t.Errorf("expected alice to have 2 entitlements, got %d", len(reply.Entitlements))
+	}
+
+	if reply = c.checkEntitlements(ctx, []string{"web.dstcorp.io"}, "server"); !reply.Permitted {
+		t.Errorf("expected the dry-run to be permitted: %s", reply.Reason)
+	}
+
+	// code signing is only issued to those the policy names it for
+	sre := metadata.NewContext(context.Background(),
+		metadata.Pairs(remoteUserMetadataKey, "bob@dstcorp.com", remoteGroupsMetadataKey, "sre"))
+	_, _, err = c.CreateCertificate(sre, "db.svc.dstcorp.io", nil, 24*time.Hour, "", "code-signing", pkix.Name{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "may not request it with the code-signing profile") {
+		t.Errorf("expected code signing to be refused, got %v", err)
+	}
+	release := metadata.NewContext(context.Background(),
+		metadata.Pairs(remoteUserMetadataKey, "carol@dstcorp.com", remoteGroupsMetadataKey, "release"))
+	if _, _, err = c.CreateCertificate(release, "build.dstcorp.io", nil, 24*time.Hour, "", "code-signing", pkix.Name{}, nil); err != nil {
+		t.Errorf("expected code signing to be granted: %s", err)
+	}
+}
+
+func TestRevocationPolicy(t *testing.T) {
+	c := newTestCA(t)
+	s := &server{ca: c, cas: []*ca{c}, store: c.Store}
+	alice := metadata.NewContext(context.Background(), metadata.Pairs(remoteUserMetadataKey, "alice@dstcorp.com"))
+	bob := metadata.NewContext(context.Background(), metadata.Pairs(remoteUserMetadataKey, "bob@dstcorp.com"))
+
+	issue := func(names ...string) string {
+		certPEM, _, err := c.CreateCertificate(alice, names[0], names[1:], 24*time.Hour, "", "server", pkix.Name{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return SerialString(parseTestCertificate(t, certPEM).SerialNumber)
+	}
+	web, payroll := issue("web.dstcorp.io"), issue("web.dstcorp.io", "payroll.dstcorp.io")
+	c.Policy = loadTestPolicy(t)
+
+	for _, test := range []struct {
+		ctx    context.Context
+		serial string
+		code   codes.Code
+	}{
+		{bob, web, codes.PermissionDenied},
+		{alice, payroll, codes.PermissionDenied},
+		{alice, web, codes.OK},
+	} {
+		_, err := s.RevokeCertificate(test.ctx, &pb.RevokeRequest{Serial: test.serial})
+		if status.Code(err) != test.code {
+			t.Errorf("%s revoking %s: expected %s, got %v", requester(test.ctx), test.serial, test.code, err)
+		}
+	}
+
+	if rec, err := c.Store.Get(context.Background(), payroll); err != nil || rec.Revoked {
+		t.Errorf("expected %s not to be revoked (%v)", payroll, err)
+	}
+}
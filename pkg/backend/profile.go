@@ -0,0 +1,204 @@
+package backend
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mchudgins/certMgr/pkg/certMgr"
+)
+
+// the types of subject alternate name a profile may permit
+const (
+	sanDNS   = "dns"
+	sanIP    = "ip"
+	sanEmail = "email"
+	sanURI   = "uri"
+)
+
+var (
+	// id-pe-tlsfeature, RFC 7633
+	oidTLSFeature = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+	// DER encoding of the TLS feature list containing status_request (5)
+	mustStapleValue = []byte{0x30, 0x03, 0x02, 0x01, 0x05}
+
+	keyUsages = map[string]x509.KeyUsage{
+		"digitalsignature":  x509.KeyUsageDigitalSignature,
+		"contentcommitment": x509.KeyUsageContentCommitment,
+		"keyencipherment":   x509.KeyUsageKeyEncipherment,
+		"dataencipherment":  x509.KeyUsageDataEncipherment,
+		"keyagreement":      x509.KeyUsageKeyAgreement,
+		"certsign":          x509.KeyUsageCertSign,
+		"crlsign":           x509.KeyUsageCRLSign,
+	}
+
+	extKeyUsages = map[string]x509.ExtKeyUsage{
+		"any":             x509.ExtKeyUsageAny,
+		"serverauth":      x509.ExtKeyUsageServerAuth,
+		"clientauth":      x509.ExtKeyUsageClientAuth,
+		"codesigning":     x509.ExtKeyUsageCodeSigning,
+		"emailprotection": x509.ExtKeyUsageEmailProtection,
+		"timestamping":    x509.ExtKeyUsageTimeStamping,
+		"ocspsigning":     x509.ExtKeyUsageOCSPSigning,
+	}
+)
+
+// profile controls the key usage & extensions of an issued certificate
+type profile struct {
+	Name        string
+	KeyUsage    x509.KeyUsage
+	ExtKeyUsage []x509.ExtKeyUsage
+	IsCA        bool
+	MaxPathLen  int
+	MustStaple  bool
+	MaxDuration time.Duration
+	SANTypes    []string
+}
+
+// newProfiles converts the configured profiles into their x509 equivalents
+func newProfiles(cfg map[string]certMgr.ProfileConfig) (map[string]*profile, error) {
+	profiles := make(map[string]*profile, len(cfg))
+
+	for name, pc := range cfg {
+		p, err := newProfile(name, pc)
+		if err != nil {
+			return nil, err
+		}
+		profiles[p.Name] = p
+	}
+
+	return profiles, nil
+}
+
+func newProfile(name string, cfg certMgr.ProfileConfig) (*profile, error) {
+	p := &profile{
+		Name:        strings.ToLower(name),
+		IsCA:        cfg.IsCA,
+		MaxPathLen:  cfg.MaxPathLen,
+		MustStaple:  cfg.MustStaple,
+		MaxDuration: time.Duration(cfg.MaxDuration) * time.Hour * 24,
+	}
+
+	for _, ku := range cfg.KeyUsage {
+		usage, ok := keyUsages[strings.ToLower(ku)]
+		if !ok {
+			return nil, fmt.Errorf("profile %s: unknown key usage %s", name, ku)
+		}
+		p.KeyUsage |= usage
+	}
+
+	for _, eku := range cfg.ExtKeyUsage {
+		usage, ok := extKeyUsages[strings.ToLower(eku)]
+		if !ok {
+			return nil, fmt.Errorf("profile %s: unknown extended key usage %s", name, eku)
+		}
+		p.ExtKeyUsage = append(p.ExtKeyUsage, usage)
+	}
+
+	for _, t := range cfg.AllowedSANTypes {
+		t = strings.ToLower(t)
+		switch t {
+		case sanDNS, sanIP, sanEmail, sanURI:
+			p.SANTypes = append(p.SANTypes, t)
+		default:
+			return nil, fmt.Errorf("profile %s: unknown subject alternate name type %s", name, t)
+		}
+	}
+
+	return p, nil
+}
+
+// lookupProfile finds the named profile, verifying that requesters may use it: the
+// CA's allowlist must include it, or its policy must grant it explicitly. The OCSP
+// signing profile is reserved for the CA's responder.
+func (c *ca) lookupProfile(name string) (*profile, error) {
+	if len(name) == 0 {
+		name = c.DefaultProfile
+	}
+	name = strings.ToLower(name)
+
+	p, ok := c.Profiles[name]
+	if !ok {
+		return nil, policyErrorf("profile %s is not defined (available profiles: %s)",
+			name, strings.Join(c.profileNames(), ", "))
+	}
+
+	if name == ocspSigningProfile {
+		return nil, policyErrorf("profile %s is reserved for the CA's OCSP responder", name)
+	}
+
+	if !c.allowsProfile(name) && (c.Policy == nil || !c.Policy.grantsProfile(name)) {
+		return nil, policyErrorf("profile %s is not permitted by this CA", name)
+	}
+
+	return p, nil
+}
+
+// allowsProfile returns true if the CA's allowlist, or the default allowlist
+// if the CA has none, includes the profile
+func (c *ca) allowsProfile(name string) bool {
+	allowed := c.AllowedProfiles
+	if len(allowed) == 0 {
+		allowed = certMgr.DefaultAllowedProfiles
+	}
+
+	for _, a := range allowed {
+		if strings.ToLower(a) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// profileNames returns the sorted names of the CA's profiles
+func (c *ca) profileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// checkRequest verifies the request is consistent with the profile
+func (p *profile) checkRequest(hosts []string) error {
+	for _, h := range hosts {
+		t := sanType(h)
+		if !p.permitsSANType(t) {
+			return policyErrorf("%s: %s names are not permitted by the %s profile", h, t, p.Name)
+		}
+	}
+
+	return nil
+}
+
+func (p *profile) permitsSANType(t string) bool {
+	for _, s := range p.SANTypes {
+		if s == t {
+			return true
+		}
+	}
+	return false
+}
+
+// apply sets the template's key usage, extended key usage & extensions
+func (p *profile) apply(template *x509.Certificate) {
+	template.KeyUsage = p.KeyUsage
+	template.ExtKeyUsage = p.ExtKeyUsage
+	template.BasicConstraintsValid = true
+	template.IsCA = p.IsCA
+	if p.IsCA {
+		template.MaxPathLen = p.MaxPathLen
+		template.MaxPathLenZero = p.MaxPathLen == 0
+	}
+
+	if p.MustStaple {
+		template.ExtraExtensions = append(template.ExtraExtensions,
+			pkix.Extension{Id: oidTLSFeature, Value: mustStapleValue})
+	}
+}
@@ -0,0 +1,204 @@
+package backend
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mchudgins/certMgr/pkg/certMgr"
+	"golang.org/x/net/context"
+)
+
+func TestNewProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     certMgr.ProfileConfig
+		failure string // substring of the expected error, empty if valid
+	}{
+		{"Server", certMgr.ProfileConfig{KeyUsage: []string{"digitalSignature", "KeyEncipherment"},
+			ExtKeyUsage: []string{"serverAuth"}, AllowedSANTypes: []string{"DNS", "ip"}, MaxDuration: 90}, ""},
+		{"bad-ku", certMgr.ProfileConfig{KeyUsage: []string{"signEverything"}}, "unknown key usage signEverything"},
+		{"bad-eku", certMgr.ProfileConfig{ExtKeyUsage: []string{"smartCardLogon"}}, "unknown extended key usage smartCardLogon"},
+		{"bad-san", certMgr.ProfileConfig{AllowedSANTypes: []string{"upn"}}, "unknown subject alternate name type upn"},
+	}
+
+	for _, test := range tests {
+		p, err := newProfile(test.name, test.cfg)
+		if len(test.failure) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.failure) {
+				t.Errorf("%s: expected %q, got %v", test.name, test.failure, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		if p.Name != "server" || p.KeyUsage != x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment ||
+			!reflect.DeepEqual(p.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}) ||
+			!reflect.DeepEqual(p.SANTypes, []string{sanDNS, sanIP}) || p.MaxDuration != 90*24*time.Hour {
+			t.Errorf("%s: unexpected profile %+v", test.name, p)
+		}
+	}
+}
+
+func TestProfileApply(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  certMgr.ProfileConfig
+		ca   bool
+		zero bool // MaxPathLenZero
+		ext  int  // # of extra extensions
+	}{
+		{"server", certMgr.DefaultProfiles["server"], false, false, 0},
+		{"must-staple", certMgr.ProfileConfig{KeyUsage: []string{"digitalSignature"}, MustStaple: true}, false, false, 1},
+		{"sub-ca", certMgr.ProfileConfig{KeyUsage: []string{"certSign", "crlSign"}, IsCA: true}, true, true, 0},
+		{"sub-ca-1", certMgr.ProfileConfig{KeyUsage: []string{"certSign"}, IsCA: true, MaxPathLen: 1}, true, false, 0},
+	}
+
+	for _, test := range tests {
+		p, err := newProfile(test.name, test.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{}
+		p.apply(template)
+
+		if template.KeyUsage != p.KeyUsage || !reflect.DeepEqual(template.ExtKeyUsage, p.ExtKeyUsage) {
+			t.Errorf("%s: expected the profile's key usage, got %v & %v", test.name, template.KeyUsage, template.ExtKeyUsage)
+		}
+		if !template.BasicConstraintsValid || template.IsCA != test.ca || template.MaxPathLenZero != test.zero {
+			t.Errorf("%s: unexpected basic constraints CA=%t, MaxPathLenZero=%t", test.name, template.IsCA, template.MaxPathLenZero)
+		}
+		if test.ca && template.MaxPathLen != test.cfg.MaxPathLen {
+			t.Errorf("%s: expected a MaxPathLen of %d, got %d", test.name, test.cfg.MaxPathLen, template.MaxPathLen)
+		}
+		if len(template.ExtraExtensions) != test.ext ||
+			(test.ext > 0 && !template.ExtraExtensions[0].Id.Equal(oidTLSFeature)) {
+			t.Errorf("%s: unexpected extensions %v", test.name, template.ExtraExtensions)
+		}
+	}
+}
+
+// newTestProfiles creates a CA with only the default profiles
+func newTestProfiles(t *testing.T) *ca {
+	profiles, err := newProfiles(certMgr.DefaultProfiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ca{Profiles: profiles}
+}
+
+func TestProfileCheckRequest(t *testing.T) {
+	c := newTestProfiles(t)
+
+	tests := []struct {
+		profile string
+		hosts   []string
+		refused bool
+	}{
+		{"server", []string{"foo.dstcorp.io", "10.1.2.3"}, false},
+		{"server", []string{"foo.dstcorp.io", "bob@dstcorp.io"}, true},
+		{"client", []string{"foo.dstcorp.io", "spiffe://svc.dstcorp.io/db"}, false},
+		{"email", []string{"bob@dstcorp.io"}, false},
+		{"email", []string{"foo.dstcorp.io"}, true},
+		{"code-signing", []string{"foo.dstcorp.io", "10.1.2.3"}, true},
+	}
+
+	for _, test := range tests {
+		err := c.Profiles[test.profile].checkRequest(test.hosts)
+		switch {
+		case test.refused && err == nil:
+			t.Errorf("%s %v: expected a refusal", test.profile, test.hosts)
+		case !test.refused && err != nil:
+			t.Errorf("%s %v: unexpected refusal: %s", test.profile, test.hosts, err)
+		case err != nil:
+			if _, ok := err.(*policyError); !ok {
+				t.Errorf("%s %v: expected a policy error, got %v", test.profile, test.hosts, err)
+			}
+		}
+	}
+}
+
+func TestLookupProfile(t *testing.T) {
+	c := newTestProfiles(t)
+	c.DefaultProfile = "server"
+
+	tests := []struct {
+		name     string
+		allowed  []string
+		expected string
+		refusal  string // substring of the expected refusal, empty if found
+	}{
+		{"", nil, "server", ""},
+		{"Client", nil, "client", ""},
+		{"nope", nil, "", "profile nope is not defined"},
+		{"client", []string{"server", "mtls"}, "", "profile client is not permitted"},
+		{"MTLS", []string{"server", "mtls"}, "mtls", ""},
+		{"", []string{"client"}, "", "profile server is not permitted"},
+		{"email", nil, "email", ""},
+		{"code-signing", nil, "", "profile code-signing is not permitted"},
+		{"code-signing", []string{"server", "code-signing"}, "code-signing", ""},
+		{"ocsp-signing", nil, "", "reserved for the CA's OCSP responder"},
+		{"ocsp-signing", []string{"ocsp-signing"}, "", "reserved for the CA's OCSP responder"},
+	}
+
+	for _, test := range tests {
+		c.AllowedProfiles = test.allowed
+		p, err := c.lookupProfile(test.name)
+		if len(test.refusal) > 0 {
+			if _, ok := err.(*policyError); !ok || !strings.Contains(err.Error(), test.refusal) {
+				t.Errorf("%q: expected a policy error %q, got %v", test.name, test.refusal, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.name, err)
+		} else if p.Name != test.expected {
+			t.Errorf("%q: expected the %s profile, got %s", test.name, test.expected, p.Name)
+		}
+	}
+}
+
+func TestDefaultAllowedProfiles(t *testing.T) {
+	c := newTestCA(t)
+	ctx := context.Background()
+
+	key, err := generateKey(KeyTypeECDSAP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "ocsp.dstcorp.io"}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+
+	// a delegated OCSP responder, or code signing certificate, is not issued by default
+	for _, profileName := range []string{"ocsp-signing", "code-signing"} {
+		_, _, err = c.CreateCertificate(ctx, "ocsp.dstcorp.io", nil, day, "", profileName, pkix.Name{}, nil)
+		if _, ok := err.(*policyError); !ok {
+			t.Errorf("%s: expected the profile to be refused, got %v", profileName, err)
+		}
+		_, err = c.SignCertificateRequest(ctx, csrPEM, day, profileName, nil)
+		if _, ok := err.(*policyError); !ok {
+			t.Errorf("%s: expected the CSR to be refused, got %v", profileName, err)
+		}
+	}
+
+	// while the CA's responder still issues its own
+	cert, _, err := newOCSPResponder(c, c.Store, time.Hour).responderCertificate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cert.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning}) {
+		t.Errorf("expected an OCSP signing certificate, got %v", cert.ExtKeyUsage)
+	}
+}
@@ -0,0 +1,137 @@
+package backend
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/mchudgins/certMgr/pkg/certMgr"
+	pb "github.com/mchudgins/certMgr/pkg/service"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// remoteSigner is a crypto.Signer whose key is held by a remote signer (`certMgr signer`)
+type remoteSigner struct {
+	client  pb.SignerClient
+	pub     crypto.PublicKey
+	timeout time.Duration
+}
+
+func (s *remoteSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+func (s *remoteSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	req := &pb.SignDigestRequest{Digest: digest}
+	if opts.HashFunc() != 0 {
+		req.Hash = opts.HashFunc().String()
+	}
+	if pss, ok := opts.(*rsa.PSSOptions); ok {
+		req.Pss = true
+		req.SaltLength = int32(pss.SaltLength)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	reply, err := s.client.Sign(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return reply.GetSignature(), nil
+}
+
+// dialRemoteSigner connects to the remote signer over mutually authenticated TLS
+// and retrieves the CA's public key
+func dialRemoteSigner(cfg certMgr.RemoteSignerConfig) (*tokenSigner, error) {
+	logger := log.WithField("signer", cfg.Address)
+
+	tlsConfig, err := remoteSignerTLSConfig(cfg)
+	if err != nil {
+		logger.WithError(err).Error("Unable to configure TLS for the remote signer")
+		return nil, err
+	}
+
+	conn, err := grpc.Dial(cfg.Address, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	if err != nil {
+		logger.WithError(err).Error("Unable to connect to the remote signer")
+		return nil, err
+	}
+
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	client := pb.NewSignerClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	reply, err := client.PublicKey(ctx, &pb.PublicKeyRequest{})
+	if err != nil {
+		conn.Close()
+		logger.WithError(err).Error("Unable to retrieve the CA's public key from the remote signer")
+		return nil, fmt.Errorf("Unable to retrieve the CA's public key from the remote signer %s: %s", cfg.Address, err)
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(reply.GetPublicKey())
+	if err != nil {
+		conn.Close()
+		logger.WithError(err).Error("Unable to parse the CA's public key")
+		return nil, err
+	}
+
+	logger.Info("using the CA key held by the remote signer")
+
+	return &tokenSigner{
+		Signer: &remoteSigner{client: client, pub: pub, timeout: timeout},
+		token:  cfg.Address,
+		closer: conn,
+	}, nil
+}
+
+func remoteSignerTLSConfig(cfg certMgr.RemoteSignerConfig) (*tls.Config, error) {
+	if len(cfg.CertFilename) == 0 || len(cfg.KeyFilename) == 0 || len(cfg.CAFilename) == 0 {
+		return nil, errors.New("the remote signer requires a client certificate, key & CA bundle")
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.CertFilename, cfg.KeyFilename)
+	if err != nil {
+		return nil, err
+	}
+
+	roots, err := loadCertPool(cfg.CAFilename)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      roots,
+		ServerName:   cfg.ServerName,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// loadCertPool reads a bundle of PEM encoded CA certificates
+func loadCertPool(filename string) (*x509.CertPool, error) {
+	bundle, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("%s contains no PEM encoded certificates", filename)
+	}
+	return pool, nil
+}
@@ -0,0 +1,203 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	pb "github.com/mchudgins/certMgr/pkg/service"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	errRenewRevoked   = errors.New("a revoked certificate cannot be renewed")
+	errAlreadyRenewed = errors.New("certificate has already been renewed")
+	errKeyMismatch    = policyErrorf("The certificate signing request's key does not match the certificate being renewed")
+)
+
+// RenewCertificate reissues an inventoried certificate
+func (s *server) RenewCertificate(ctx context.Context, in *pb.RenewRequest) (*pb.RenewReply, error) {
+	var validFor time.Duration
+	validFor = time.Duration(in.GetDuration()) * time.Hour * 24
+
+	c := s.issuingCA(ctx, in.GetSerial())
+	cert, key, err := c.Renew(ctx, in.GetSerial(), validFor, in.GetKeyType(), in.GetCsr(), in.GetLabels())
+	switch err {
+	case nil:
+	case ErrCertificateNotFound:
+		return nil, status.Errorf(codes.NotFound, "certificate %s not found", in.GetSerial())
+	case errRenewRevoked, errAlreadyRenewed:
+		return nil, status.Errorf(codes.FailedPrecondition, "certificate %s: %s", in.GetSerial(), err)
+	default:
+		return nil, statusError(err)
+	}
+
+	return &pb.RenewReply{Certificate: cert.PEM, Key: key, Chain: c.Bundle, Serial: cert.Serial}, nil
+}
+
+// Renew reissues the certificate with the same names, profile & subject under
+// the CA's current policy, by default for the original's lifetime. When csrPEM
+// is supplied its signature proves possession of the original key, which is
+// reused; otherwise a new key is generated and returned. The original and
+// renewed certificates are linked in the inventory.
+func (c ca) Renew(ctx context.Context,
+	serial string,
+	duration time.Duration,
+	keyType string,
+	csrPEM string,
+	labels map[string]string) (renewed *CertificateRecord, key string, err error) {
+
+	if c.Store == nil {
+		return nil, "", errors.New("renewal requires a certificate store")
+	}
+
+	rec, err := c.Store.Get(ctx, serial)
+	if err != nil {
+		return nil, "", err
+	}
+	if rec.CA != c.Name {
+		return nil, "", ErrCertificateNotFound
+	}
+	if rec.Revoked {
+		return nil, "", errRenewRevoked
+	}
+	if len(rec.RenewedBy) > 0 {
+		return nil, "", errAlreadyRenewed
+	}
+
+	block, _ := pem.Decode([]byte(rec.PEM))
+	if block == nil {
+		return nil, "", fmt.Errorf("Unable to decode certificate %s from the inventory", rec.Serial)
+	}
+	original, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	prof, err := c.lookupProfile(rec.Profile)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// by default, the renewal has the original's lifetime (within the current maximum)
+	if duration == 0 {
+		duration = original.NotAfter.Sub(original.NotBefore)
+		if max := c.maxDuration(prof); max > 0 && duration > max {
+			duration = max
+		}
+	}
+
+	hosts, err := c.validateRequest(ctx, uniqueHosts(original.Subject.CommonName, original.DNSNames,
+		original.IPAddresses, original.EmailAddresses, original.URIs), duration, prof)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err = prof.checkRequest(hosts); err != nil {
+		return nil, "", err
+	}
+
+	duration, err = c.checkDuration(duration, prof)
+	if err != nil {
+		return nil, "", err
+	}
+
+	subject, err := c.Subject.subject(hosts[0], original.Subject)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// reuse the original key, or generate a new one
+	var pub interface{}
+	if len(csrPEM) > 0 {
+		csr, err := parseCertificateRequest([]byte(csrPEM))
+		if err != nil {
+			return nil, "", err
+		}
+
+		csrKey, err := x509.MarshalPKIXPublicKey(csr.PublicKey)
+		if err != nil || !bytes.Equal(csrKey, original.RawSubjectPublicKeyInfo) {
+			return nil, "", errKeyMismatch
+		}
+		pub = csr.PublicKey
+	} else {
+		if len(keyType) == 0 {
+			keyType = keyTypeOf(original.PublicKey)
+		}
+		if !c.permitsKeyType(keyType) {
+			return nil, "", policyErrorf("key type %s is not permitted by this CA", keyType)
+		}
+
+		priv, err := generateKey(keyType)
+		if err != nil {
+			return nil, "", err
+		}
+		pub = publicKey(priv)
+
+		var keyBuffer bytes.Buffer
+		pem.Encode(&keyBuffer, pemBlockForKey(priv))
+		key = keyBuffer.String()
+	}
+
+	// the original labels, updated by those of the request
+	merged := make(map[string]string, len(rec.Labels)+len(labels))
+	for k, v := range rec.Labels {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+
+	template := newTemplate(subject, hosts, duration, prof)
+	newSerial := SerialString(template.SerialNumber)
+
+	// link the original to the renewal before signing, so that of concurrent
+	// renewals only one issues a certificate
+	_, err = c.Store.Update(ctx, rec.Serial, func(old *CertificateRecord) error {
+		if old.Revoked {
+			return errRenewRevoked
+		}
+		if len(old.RenewedBy) > 0 {
+			return errAlreadyRenewed
+		}
+		old.RenewedBy = newSerial
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if _, err = c.sign(ctx, template, pub, prof, merged); err != nil {
+		// release the original for another renewal
+		_, releaseErr := c.Store.Update(ctx, rec.Serial, func(old *CertificateRecord) error {
+			if old.RenewedBy == newSerial {
+				old.RenewedBy = ""
+			}
+			return nil
+		})
+		if releaseErr != nil {
+			log.WithError(releaseErr).WithFields(log.Fields{"serial": rec.Serial, "renewedBy": newSerial}).
+				Error("Unable to release the failed renewal")
+		}
+		return nil, "", err
+	}
+
+	renewed, err = c.Store.Update(ctx, newSerial, func(r *CertificateRecord) error {
+		r.Renews = rec.Serial
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	log.WithFields(log.Fields{"serial": rec.Serial, "renewedBy": newSerial, "requester": requester(ctx)}).
+		Info("certificate renewed")
+
+	return renewed, key, nil
+}
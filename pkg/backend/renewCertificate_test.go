@@ -0,0 +1,193 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestRenew(t *testing.T) {
+	ctx := context.Background()
+	c := newTestCA(t)
+
+	certPEM, _, err := c.CreateCertificate(ctx, "foo.dstcorp.io", []string{"foo.dstcorp.io", "bar.dstcorp.io"},
+		24*time.Hour, KeyTypeECDSAP384, "server", pkix.Name{}, map[string]string{"team": "sre", "env": "dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := parseTestCertificate(t, certPEM)
+	serial := SerialString(original.SerialNumber)
+
+	// renew with a new key
+	renewed, key, err := c.Renew(ctx, serial, 0, "", "", map[string]string{"env": "prod"})
+	if err != nil {
+		t.Fatalf("Renew: %s", err)
+	}
+	if len(key) == 0 {
+		t.Error("expected a new private key")
+	}
+
+	cert := parseTestCertificate(t, renewed.PEM)
+	if !reflect.DeepEqual(cert.DNSNames, original.DNSNames) {
+		t.Errorf("expected SANs %v, got %v", original.DNSNames, cert.DNSNames)
+	}
+	if cert.Subject.String() != original.Subject.String() {
+		t.Errorf("expected subject %s, got %s", original.Subject, cert.Subject)
+	}
+	if keyTypeOf(cert.PublicKey) != KeyTypeECDSAP384 {
+		t.Errorf("expected the original key type, got %s", keyTypeOf(cert.PublicKey))
+	}
+	if renewed.Profile != "server" || renewed.Renews != serial {
+		t.Errorf("unexpected renewal record: %+v", renewed)
+	}
+	if !reflect.DeepEqual(renewed.Labels, map[string]string{"team": "sre", "env": "prod"}) {
+		t.Errorf("expected merged labels, got %v", renewed.Labels)
+	}
+
+	old, err := c.Store.Get(ctx, serial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.RenewedBy != renewed.Serial {
+		t.Errorf("expected the original to be renewed by %s, got %s", renewed.Serial, old.RenewedBy)
+	}
+
+	if _, _, err = c.Renew(ctx, serial, 0, "", "", nil); err != errAlreadyRenewed {
+		t.Errorf("expected errAlreadyRenewed, got %v", err)
+	}
+
+	// renew again, reusing the key
+	block, _ := pem.Decode([]byte(key))
+	priv, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr := func(signer interface{}) string {
+		der, err := x509.CreateCertificateRequest(rand.Reader,
+			&x509.CertificateRequest{Subject: pkix.Name{CommonName: "foo.dstcorp.io"}}, signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+	}
+
+	otherKey, err := generateKey(KeyTypeECDSAP384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = c.Renew(ctx, renewed.Serial, 0, "", csr(otherKey), nil); err != errKeyMismatch {
+		t.Errorf("expected errKeyMismatch, got %v", err)
+	}
+
+	again, key, err := c.Renew(ctx, renewed.Serial, 0, "", csr(priv), nil)
+	if err != nil {
+		t.Fatalf("Renew with CSR: %s", err)
+	}
+	if len(key) != 0 {
+		t.Error("expected no private key when a CSR is supplied")
+	}
+	if !bytes.Equal(parseTestCertificate(t, again.PEM).RawSubjectPublicKeyInfo, cert.RawSubjectPublicKeyInfo) {
+		t.Error("expected the renewed certificate to reuse the key")
+	}
+
+	// revoked certificates are not renewed
+	if _, err = c.Revoke(ctx, again.Serial, ReasonSuperseded); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = c.Renew(ctx, again.Serial, 0, "", "", nil); err != errRenewRevoked {
+		t.Errorf("expected errRenewRevoked, got %v", err)
+	}
+}
+
+func parseTestCertificate(t *testing.T, certPEM string) *x509.Certificate {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		t.Fatal("Unable to decode the certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestConcurrentRenewals(t *testing.T) {
+	ctx := context.Background()
+	c := newTestCA(t)
+
+	certPEM, _, err := c.CreateCertificate(ctx, "foo.dstcorp.io", nil, 24*time.Hour, "", "", pkix.Name{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := SerialString(parseTestCertificate(t, certPEM).SerialNumber)
+
+	const renewals = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, renewals)
+	for i := 0; i < renewals; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := c.Renew(ctx, serial, 0, "", "", nil)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	renewed := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			renewed++
+		case errAlreadyRenewed:
+		default:
+			t.Errorf("unexpected error %s", err)
+		}
+	}
+	if renewed != 1 {
+		t.Errorf("expected exactly one renewal, got %d", renewed)
+	}
+
+	// no other certificate was issued
+	recs, _, err := c.Store.List(ctx, &CertificateFilter{CA: c.Name}, "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 {
+		t.Errorf("expected the original & its renewal in the inventory, got %d certificates", len(recs))
+	}
+}
+
+func TestRenewReleasedOnFailure(t *testing.T) {
+	ctx := context.Background()
+	c := newTestCA(t)
+
+	certPEM, _, err := c.CreateCertificate(ctx, "foo.dstcorp.io", nil, 24*time.Hour, "", "", pkix.Name{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := SerialString(parseTestCertificate(t, certPEM).SerialNumber)
+
+	// a CA unable to sign leaves the original free to be renewed
+	broken := *c
+	broken.SigningKey = nil
+	if _, _, err = broken.Renew(ctx, serial, 0, "", "", nil); err == nil {
+		t.Fatal("expected the renewal to fail")
+	}
+	if rec, err := c.Store.Get(ctx, serial); err != nil || len(rec.RenewedBy) > 0 {
+		t.Errorf("expected the original to be released, got %+v (%v)", rec, err)
+	}
+
+	if _, _, err = c.Renew(ctx, serial, 0, "", "", nil); err != nil {
+		t.Errorf("expected the renewal to succeed, got %s", err)
+	}
+}
@@ -0,0 +1,65 @@
+package backend
+
+import (
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+)
+
+// the frontend's security proxy passes the authenticated user ID
+// to the backend as Grpc-Metadata-X-RemoteUser
+const remoteUserMetadataKey = "x-remoteuser"
+
+// and the user's groups, comma separated, as Grpc-Metadata-X-RemoteGroups
+const remoteGroupsMetadataKey = "x-remotegroups"
+
+// and the request's correlation ID as Grpc-Metadata-X-Correlation-ID
+const correlationIDMetadataKey = "x-correlation-id"
+
+// requester returns the authenticated user ID of the caller, if any
+func requester(ctx context.Context) string {
+	md, ok := metadata.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	if values := md[remoteUserMetadataKey]; len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// requesterGroups returns the groups of the authenticated caller, if any
+func requesterGroups(ctx context.Context) []string {
+	md, ok := metadata.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	var groups []string
+	for _, value := range md[remoteGroupsMetadataKey] {
+		for _, group := range strings.Split(value, ",") {
+			if group = strings.TrimSpace(group); len(group) > 0 {
+				groups = append(groups, group)
+			}
+		}
+	}
+
+	return groups
+}
+
+// correlationID returns the correlation ID of the request, if any
+func correlationID(ctx context.Context) string {
+	md, ok := metadata.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	if values := md[correlationIDMetadataKey]; len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
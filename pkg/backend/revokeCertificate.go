@@ -0,0 +1,119 @@
+package backend
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	pb "github.com/mchudgins/certMgr/pkg/service"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errAlreadyRevoked = errors.New("certificate has already been revoked")
+
+// RevokeCertificate revokes an issued certificate and publishes a new CRL
+func (s *server) RevokeCertificate(ctx context.Context, in *pb.RevokeRequest) (*pb.RevokeReply, error) {
+	reason := int(in.GetReason())
+	if !validRevocationReason(reason) {
+		return nil, status.Errorf(codes.InvalidArgument, "%d is not a valid revocation reason", reason)
+	}
+
+	rec, err := s.issuingCA(ctx, in.GetSerial()).Revoke(ctx, in.GetSerial(), reason)
+	var refusal *policyError
+	switch {
+	case err == nil:
+	case err == ErrCertificateNotFound:
+		return nil, status.Errorf(codes.NotFound, "certificate %s not found", in.GetSerial())
+	case err == errAlreadyRevoked:
+		return nil, status.Errorf(codes.FailedPrecondition, "certificate %s has already been revoked", in.GetSerial())
+	case errors.As(err, &refusal):
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	default:
+		return nil, status.Errorf(codes.Internal, "unable to revoke certificate %s: %s", in.GetSerial(), err)
+	}
+
+	return &pb.RevokeReply{Serial: rec.Serial, RevokedAt: rec.RevokedAt.Unix()}, nil
+}
+
+// Revoke marks the certificate as revoked in the inventory, discards any cached
+// OCSP response, then regenerates the CRL
+func (c *ca) Revoke(ctx context.Context, serial string, reason int) (*CertificateRecord, error) {
+	if err := c.checkRevocation(ctx, serial); err != nil {
+		return nil, err
+	}
+
+	rec, err := c.Store.Update(ctx, serial, func(rec *CertificateRecord) error {
+		if rec.CA != c.Name {
+			return ErrCertificateNotFound
+		}
+		if rec.Revoked {
+			return errAlreadyRevoked
+		}
+
+		rec.Revoked = true
+		rec.RevokedAt = time.Now().UTC().Truncate(time.Second)
+		rec.RevocationReason = reason
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{"serial": rec.Serial, "reason": reason, "requester": requester(ctx)}).
+		Info("certificate revoked")
+
+	if c.OCSP != nil {
+		c.OCSP.invalidate(rec.Serial)
+	}
+
+	// the revocation stands regardless; a failed CRL is published by the next scheduled run
+	if c.CRL != nil {
+		if err = c.CRL.generate(ctx); err != nil {
+			log.WithError(err).WithField("serial", rec.Serial).Error("Unable to publish the revocation in the CRL")
+		}
+	}
+
+	return rec, nil
+}
+
+// checkRevocation returns a policy error unless the caller is entitled to every
+// name in the certificate, with its profile, as they would be to renew it
+func (c *ca) checkRevocation(ctx context.Context, serial string) error {
+	if c.Policy == nil {
+		return nil
+	}
+
+	rec, err := c.Store.Get(ctx, serial)
+	if err != nil {
+		return err
+	}
+	if rec.CA != c.Name {
+		return ErrCertificateNotFound
+	}
+
+	block, _ := pem.Decode([]byte(rec.PEM))
+	if block == nil {
+		return fmt.Errorf("Unable to decode certificate %s from the inventory", rec.Serial)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return err
+	}
+
+	user, groups := requester(ctx), requesterGroups(ctx)
+	for _, name := range uniqueHosts(cert.Subject.CommonName, cert.DNSNames, cert.IPAddresses,
+		cert.EmailAddresses, cert.URIs) {
+		if err = c.Policy.check(user, groups, name, rec.Profile, !c.allowsProfile(rec.Profile)); err != nil {
+			log.WithError(err).WithFields(log.Fields{"serial": rec.Serial, "requester": user}).
+				Warn("revocation refused by policy")
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package backend
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	pb "github.com/mchudgins/certMgr/pkg/service"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// SignCertificateRequest signs a client supplied certificate signing request.
+// The private key never leaves the client; only the certificate and the
+// chain of intermediate CA's are returned.
+func (s *server) SignCertificateRequest(ctx context.Context, in *pb.SignRequest) (*pb.SignReply, error) {
+	var validFor time.Duration
+	validFor = time.Duration(in.GetDuration()) * time.Hour * 24
+
+	// the CA is chosen by the CSR's names; a CSR which cannot be read is left for the primary CA to refuse
+	var names []string
+	if csr, err := parseCertificateRequest([]byte(in.GetCsr())); err == nil {
+		names, _ = hostsFromCertificateRequest(csr)
+	}
+	c, err := s.selectCA(ctx, in.GetCa(), names)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	cert, err := c.SignCertificateRequest(ctx, in.GetCsr(), validFor, in.GetProfile(), in.GetLabels())
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return &pb.SignReply{Certificate: cert, Chain: c.Bundle}, nil
+}
+
+// SignCertificateRequest verifies the PEM encoded PKCS#10 CSR, validates the
+// requested names, and returns the PEM encoded certificate
+func (c ca) SignCertificateRequest(ctx context.Context,
+	csrPEM string,
+	duration time.Duration,
+	profileName string,
+	labels map[string]string) (cert string, err error) {
+
+	prof, err := c.lookupProfile(profileName)
+	if err != nil {
+		return "", err
+	}
+
+	csr, err := parseCertificateRequest([]byte(csrPEM))
+	if err != nil {
+		return "", err
+	}
+
+	// the CSR's key is held to the same allowlist as the keys the CA generates
+	keyType := keyTypeOf(csr.PublicKey)
+	if len(keyType) == 0 {
+		return "", policyErrorf("the certificate signing request's key is not a supported key type (supported types: %s)",
+			strings.Join(SupportedKeyTypes, ", "))
+	}
+	if !c.permitsKeyType(keyType) {
+		return "", policyErrorf("key type %s is not permitted by this CA", keyType)
+	}
+
+	requestedHosts, err := hostsFromCertificateRequest(csr)
+	if err != nil {
+		return "", err
+	}
+
+	hosts, err := c.validateRequest(ctx, requestedHosts, duration, prof)
+	if err != nil {
+		return "", err
+	}
+
+	if err = prof.checkRequest(hosts); err != nil {
+		return "", err
+	}
+
+	duration, err = c.checkDuration(duration, prof)
+	if err != nil {
+		return "", err
+	}
+
+	subject, err := c.Subject.subject(hosts[0], c.Subject.requesterFields(csr.Subject))
+	if err != nil {
+		return "", err
+	}
+
+	return c.sign(ctx, newTemplate(subject, hosts, duration, prof), csr.PublicKey, prof, labels)
+}
+
+// parseCertificateRequest decodes the PEM encoded CSR and verifies its signature
+func parseCertificateRequest(csrPEM []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil {
+		return nil, policyErrorf("Unable to decode the certificate signing request")
+	}
+
+	if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+		return nil, policyErrorf("PEM block type %s is not a certificate signing request", block.Type)
+	}
+
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		log.WithError(err).Error("Unable to parse the certificate signing request")
+		return nil, policyErrorf("Unable to parse the certificate signing request")
+	}
+
+	if err = csr.CheckSignature(); err != nil {
+		log.WithError(err).WithField("subject", csr.Subject.CommonName).
+			Warn("certificate signing request has an invalid signature")
+		return nil, policyErrorf("The certificate signing request's signature is invalid")
+	}
+
+	return csr, nil
+}
+
+// hostsFromCertificateRequest returns the subject's common name followed by
+// the (unique) subject alternate names found in the CSR
+func hostsFromCertificateRequest(csr *x509.CertificateRequest) ([]string, error) {
+	hosts := uniqueHosts(csr.Subject.CommonName, csr.DNSNames, csr.IPAddresses, csr.EmailAddresses, csr.URIs)
+	if len(hosts) == 0 {
+		return nil, policyErrorf("The certificate signing request does not contain a subject name")
+	}
+
+	return hosts, nil
+}
+
+// uniqueHosts lists the common name & alternate names, omitting
+// empty names and (case insensitive) duplicates
+func uniqueHosts(commonName string, dnsNames []string, ips []net.IP, emails []string, uris []*url.URL) []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	add := func(h string) {
+		key := strings.ToLower(h)
+		if len(h) > 0 && !seen[key] {
+			seen[key] = true
+			hosts = append(hosts, h)
+		}
+	}
+
+	add(commonName)
+	for _, name := range dnsNames {
+		add(name)
+	}
+	for _, ip := range ips {
+		add(ip.String())
+	}
+	for _, email := range emails {
+		add(email)
+	}
+	for _, u := range uris {
+		add(u.String())
+	}
+
+	return hosts
+}
@@ -0,0 +1,108 @@
+package backend
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"io"
+
+	"github.com/mchudgins/certMgr/pkg/certMgr"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultPINEnv is the environment variable consulted for the PKCS#11 token's PIN
+const DefaultPINEnv = "CERTMGR_PKCS11_PIN"
+
+// signerError is returned when the CA's signing key fails to sign, e.g. when
+// the token holding it is unavailable
+type signerError struct {
+	token string
+	err   error
+}
+
+func (e *signerError) Error() string {
+	return fmt.Sprintf("the CA's signing key (%s) is unavailable: %s", e.token, e.err)
+}
+
+// tokenSigner is a CA key held outside the process, such as in a PKCS#11 token.
+// Signing failures are reported as signerErrors.
+type tokenSigner struct {
+	crypto.Signer
+	token  string
+	closer io.Closer
+}
+
+func (s *tokenSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	signature, err := s.Signer.Sign(rand, digest, opts)
+	if err != nil {
+		log.WithError(err).WithField("token", s.token).Error("the token was unable to sign")
+		return nil, &signerError{token: s.token, err: err}
+	}
+	return signature, nil
+}
+
+// openTokenSigner returns the CA key held by the remote signer or the PKCS#11 token,
+// if so configured, otherwise nil
+func openTokenSigner(cfg certMgr.BackendConfig) (*tokenSigner, error) {
+	switch {
+	case len(cfg.RemoteSigner.Address) > 0:
+		return dialRemoteSigner(cfg.RemoteSigner)
+	case len(cfg.PKCS11.Module) > 0:
+		return loadPKCS11Signer(cfg.PKCS11)
+	}
+	return nil, nil
+}
+
+// pinFromConfig returns the token's PIN sources: the PIN file, if any, then the environment variable
+func pinFromConfig(cfg certMgr.PKCS11Config) PassphraseFunc {
+	var file PassphraseFunc
+	if len(cfg.PinFile) > 0 {
+		file = FilePassphrase(cfg.PinFile)
+	}
+
+	env := cfg.PinEnv
+	if len(env) == 0 {
+		env = DefaultPINEnv
+	}
+
+	return FirstPassphrase(file, EnvPassphrase(env))
+}
+
+// Close releases the token's sessions
+func (s *tokenSigner) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// signData signs the data with the CA key: a SHA-256 digest of it for RSA
+// (PKCS #1 v1.5) & ECDSA keys, the data itself for Ed25519 keys
+func signData(key crypto.Signer, data []byte) ([]byte, error) {
+	if _, ok := key.Public().(ed25519.PublicKey); ok {
+		return key.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+
+	digest := sha256.Sum256(data)
+	return key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// checkDataSignature verifies the CA certificate's signature of the data, made by signData
+func checkDataSignature(cert *x509.Certificate, data []byte, signature []byte) error {
+	var algorithm x509.SignatureAlgorithm
+	switch cert.PublicKeyAlgorithm {
+	case x509.RSA:
+		algorithm = x509.SHA256WithRSA
+	case x509.ECDSA:
+		algorithm = x509.ECDSAWithSHA256
+	case x509.Ed25519:
+		algorithm = x509.PureEd25519
+	default:
+		return fmt.Errorf("unsupported CA key algorithm %s", cert.PublicKeyAlgorithm)
+	}
+
+	return cert.CheckSignature(algorithm, data, signature)
+}
@@ -0,0 +1,307 @@
+package backend
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mchudgins/certMgr/pkg/certMgr"
+	pb "github.com/mchudgins/certMgr/pkg/service"
+	"github.com/mchudgins/certMgr/pkg/utils"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// signerService signs digests with the CA key on behalf of the backends
+// presenting an authorized client certificate
+type signerService struct {
+	key     crypto.Signer
+	clients []string
+	audit   *signatureAudit
+}
+
+// RunSigner runs the remote signer command
+func RunSigner(cfg *certMgr.AppConfig) {
+	if cfg.Verbose {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	key, err := loadSigningKey(cfg)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to load the CA key")
+	}
+
+	audit, err := newSignatureAudit(cfg.Signer.AuditFilename)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to open the audit trail")
+	}
+	defer audit.Close()
+
+	s, err := newSignerServer(cfg, &signerService{key: key, clients: lowerAll(cfg.Signer.AuthorizedClients), audit: audit})
+	if err != nil {
+		log.WithError(err).Fatal("Failed to generate grpc TLS credentials")
+	}
+
+	errc := make(chan error)
+
+	// interrupt handler
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+		errc <- fmt.Errorf("%s", <-c)
+	}()
+
+	go func() {
+		lis, err := net.Listen("tcp", cfg.Signer.ListenAddress)
+		if err != nil {
+			errc <- err
+			return
+		}
+
+		log.Infof("signer listening on %s", cfg.Signer.ListenAddress)
+		errc <- s.Serve(lis)
+	}()
+
+	log.Infof("exit: %s", <-errc)
+	s.GracefulStop()
+}
+
+// loadSigningKey loads the CA key from the PKCS#11 token or the key file
+func loadSigningKey(cfg *certMgr.AppConfig) (crypto.Signer, error) {
+	if len(cfg.Backend.PKCS11.Module) > 0 {
+		return loadPKCS11Signer(cfg.Backend.PKCS11)
+	}
+
+	key, err := utils.FindAndReadFile(cfg.Backend.SigningCAKeyFilename, "CA key")
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return nil, fmt.Errorf("Unable to decode the CA key %s", cfg.Backend.SigningCAKeyFilename)
+	}
+
+	return parseCAKey(block, PassphraseFromConfig(cfg))
+}
+
+// newSignerServer returns the signer's gRPC server, which requires
+// clients to present a certificate issued by the ClientCAFilename bundle
+func newSignerServer(cfg *certMgr.AppConfig, signer *signerService) (*grpc.Server, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFilename, cfg.KeyFilename)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Signer.ClientCAFilename) == 0 {
+		return nil, fmt.Errorf("a client CA bundle is required to verify the backends")
+	}
+	clientCAs, err := loadCertPool(cfg.Signer.ClientCAFilename)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsCreds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MinVersion:   tls.VersionTLS12,
+	})
+
+	s := grpc.NewServer(grpc.Creds(tlsCreds))
+	pb.RegisterSignerServer(s, signer)
+
+	return s, nil
+}
+
+// Sign signs the digest, recording the signature in the audit trail
+func (s *signerService) Sign(ctx context.Context, in *pb.SignDigestRequest) (*pb.SignDigestReply, error) {
+	client, err := s.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := signerOpts(in)
+	if err != nil {
+		s.audit.record(client, in, err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	signature, err := s.key.Sign(rand.Reader, in.GetDigest(), opts)
+	if auditErr := s.audit.record(client, in, err); auditErr != nil && err == nil {
+		// an unrecorded signature is never released
+		return nil, status.Error(codes.Unavailable, "Unable to record the signature in the audit trail")
+	}
+	if err != nil {
+		err = statusError(err)
+		if _, ok := status.FromError(err); !ok {
+			err = status.Errorf(codes.Internal, "Unable to sign the digest: %s", err)
+		}
+		return nil, err
+	}
+
+	return &pb.SignDigestReply{Signature: signature}, nil
+}
+
+// PublicKey returns the CA's public key
+func (s *signerService) PublicKey(ctx context.Context, in *pb.PublicKeyRequest) (*pb.PublicKeyReply, error) {
+	if _, err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(s.key.Public())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Unable to marshal the CA's public key: %s", err)
+	}
+
+	return &pb.PublicKeyReply{PublicKey: der}, nil
+}
+
+// authorize returns the name of the client's verified certificate, provided it is permitted to sign
+func (s *signerService) authorize(ctx context.Context) (string, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "no client certificate was presented")
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 {
+		return "", status.Error(codes.Unauthenticated, "no client certificate was presented")
+	}
+
+	cert := tlsInfo.State.VerifiedChains[0][0]
+	client := cert.Subject.CommonName
+
+	if len(s.clients) == 0 {
+		return client, nil
+	}
+	for _, name := range append([]string{cert.Subject.CommonName}, cert.DNSNames...) {
+		if matchesAny(s.clients, strings.ToLower(name)) {
+			return name, nil
+		}
+	}
+
+	log.WithFields(log.Fields{"client": client, "address": p.Addr}).Warn("client is not authorized to sign")
+	return "", status.Errorf(codes.PermissionDenied, "%s is not authorized to sign", client)
+}
+
+// signerOpts reconstructs the signing options of the request
+func signerOpts(in *pb.SignDigestRequest) (crypto.SignerOpts, error) {
+	var hash crypto.Hash
+	if len(in.GetHash()) > 0 {
+		for h := crypto.MD4; h <= crypto.BLAKE2b_512; h++ {
+			if h.String() == in.GetHash() {
+				hash = h
+				break
+			}
+		}
+		if hash == 0 || !hash.Available() {
+			return nil, fmt.Errorf("unsupported hash function %s", in.GetHash())
+		}
+		if len(in.GetDigest()) != hash.Size() {
+			return nil, fmt.Errorf("the digest is not a %s digest", hash)
+		}
+	}
+
+	if in.GetPss() {
+		return &rsa.PSSOptions{SaltLength: int(in.GetSaltLength()), Hash: hash}, nil
+	}
+	return hash, nil
+}
+
+// signatureAudit is an append-only record of every signature the signer makes
+type signatureAudit struct {
+	sync.Mutex
+	file *os.File
+}
+
+type signatureAuditEntry struct {
+	Time   time.Time `json:"time"`
+	Client string    `json:"client"`
+	Hash   string    `json:"hash,omitempty"`
+	PSS    bool      `json:"pss,omitempty"`
+	Digest string    `json:"digest"`
+	Error  string    `json:"error,omitempty"`
+}
+
+func newSignatureAudit(filename string) (*signatureAudit, error) {
+	a := &signatureAudit{}
+	if len(filename) == 0 {
+		log.Warn("signatures will only be recorded in the log")
+		return a, nil
+	}
+
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	a.file = f
+
+	return a, nil
+}
+
+// record logs the signature (or the refusal to sign) & appends it to the audit trail
+func (a *signatureAudit) record(client string, in *pb.SignDigestRequest, err error) error {
+	entry := signatureAuditEntry{
+		Time:   time.Now().UTC(),
+		Client: client,
+		Hash:   in.GetHash(),
+		PSS:    in.GetPss(),
+		Digest: hex.EncodeToString(in.GetDigest()),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	logger := log.WithFields(log.Fields{"client": entry.Client, "hash": entry.Hash, "digest": entry.Digest})
+	if err != nil {
+		logger.WithError(err).Warn("signature refused")
+	} else {
+		logger.Info("digest signed")
+	}
+
+	if a.file == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	_, err = a.file.Write(append(line, '\n'))
+	if err == nil {
+		err = a.file.Sync()
+	}
+	if err != nil {
+		log.WithError(err).WithField("file", a.file.Name()).Error("Unable to record the signature in the audit trail")
+	}
+	return err
+}
+
+func (a *signatureAudit) Close() error {
+	if a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
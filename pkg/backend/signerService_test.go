@@ -0,0 +1,202 @@
+package backend
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mchudgins/certMgr/pkg/certMgr"
+	pb "github.com/mchudgins/certMgr/pkg/service"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// writeTestKeyPair issues a TLS certificate for the name, writing it & its key to dir
+func writeTestKeyPair(t *testing.T, dir string, name string, ca *testCACertificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if err = ioutil.WriteFile(filepath.Join(dir, name+".pem"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, name+"-key.pem"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRemoteSigner runs the signer & a backend on localhost, connected by mutually authenticated TLS
+func TestRemoteSigner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certMgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tlsCA := newTestCACertificate(t, "tls-ca", nil, nil, time.Now().Add(time.Hour))
+	if err = ioutil.WriteFile(filepath.Join(dir, "tls-ca.pem"), []byte(tlsCA.pem()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	writeTestKeyPair(t, dir, "localhost", tlsCA)
+	writeTestKeyPair(t, dir, "backend", tlsCA)
+	writeTestKeyPair(t, dir, "mallory", tlsCA)
+
+	// the signer
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &certMgr.AppConfig{
+		CertFilename: filepath.Join(dir, "localhost.pem"),
+		KeyFilename:  filepath.Join(dir, "localhost-key.pem"),
+		Signer: certMgr.SignerConfig{
+			ClientCAFilename:  filepath.Join(dir, "tls-ca.pem"),
+			AuthorizedClients: []string{"backend"},
+			AuditFilename:     filepath.Join(dir, "audit.log"),
+		},
+	}
+	audit, err := newSignatureAudit(cfg.Signer.AuditFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+
+	s, err := newSignerServer(cfg, &signerService{key: caKey, clients: cfg.Signer.AuthorizedClients, audit: audit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(lis)
+	defer s.Stop()
+
+	remote := func(client string) certMgr.RemoteSignerConfig {
+		return certMgr.RemoteSignerConfig{
+			Address:      lis.Addr().String(),
+			ServerName:   "localhost",
+			CAFilename:   filepath.Join(dir, "tls-ca.pem"),
+			CertFilename: filepath.Join(dir, client+".pem"),
+			KeyFilename:  filepath.Join(dir, client+"-key.pem"),
+			Timeout:      5,
+		}
+	}
+
+	if _, err = dialRemoteSigner(remote("mallory")); err == nil || !strings.Contains(err.Error(), "not authorized") {
+		t.Errorf("expected mallory to be refused, got %v", err)
+	}
+
+	// the backend
+	signer, err := dialRemoteSigner(remote("backend"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer signer.Close()
+
+	caCert := newTestCACertificate(t, "test-ca.dstcorp.io", signer, nil, time.Now().Add(24*time.Hour))
+	c, err := newCA("test", []byte(caCert.pem()), signer, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = checkKeyMatchesCertificate(caKey, &c.SigningCertificate); err != nil {
+		t.Errorf("the remote signer did not sign with the CA key: %s", err)
+	}
+
+	ctx := context.Background()
+	cert, _, err := c.CreateCertificate(ctx, "foo.dstcorp.io", []string{"foo.dstcorp.io"},
+		24*time.Hour, "", "", pkix.Name{}, nil)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %s", err)
+	}
+	if err = parseTestCertificate(t, cert).CheckSignatureFrom(&c.SigningCertificate); err != nil {
+		t.Errorf("the certificate was not signed by the remote signer: %s", err)
+	}
+
+	// both signatures are in the audit trail
+	trail, err := ioutil.ReadFile(cfg.Signer.AuditFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(trail)), "\n")
+	if len(lines) != 2 {
+		t.Errorf("expected 2 audit entries, got %d", len(lines))
+	}
+	for _, line := range lines {
+		var entry signatureAuditEntry
+		if err = json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Errorf("malformed audit entry %q: %s", line, err)
+		} else if entry.Client != "backend" || entry.Hash != "SHA-256" || len(entry.Digest) != 64 {
+			t.Errorf("unexpected audit entry %+v", entry)
+		}
+	}
+
+	// requests fail cleanly while the signer is down
+	s.Stop()
+	_, _, err = c.CreateCertificate(ctx, "foo.dstcorp.io", []string{"foo.dstcorp.io"},
+		24*time.Hour, "", "", pkix.Name{}, nil)
+	if status.Code(statusError(err)) != codes.Unavailable {
+		t.Errorf("expected Unavailable, got %v", err)
+	}
+}
+
+func TestSignerOpts(t *testing.T) {
+	sha256 := make([]byte, 32)
+
+	tests := []struct {
+		hash   string
+		digest []byte
+		valid  bool
+	}{
+		{"SHA-256", sha256, true},
+		{"SHA-384", sha256, false},
+		{"SHA-257", sha256, false},
+		{"", []byte("an ed25519 message"), true},
+	}
+
+	for _, test := range tests {
+		if _, err := signerOpts(&pb.SignDigestRequest{Hash: test.hash, Digest: test.digest}); (err == nil) != test.valid {
+			t.Errorf("%q: expected valid %t, got %v", test.hash, test.valid, err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package backend
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// unpluggedSigner fails once its token has been removed
+type unpluggedSigner struct {
+	crypto.Signer
+	unplugged bool
+}
+
+func (s *unpluggedSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if s.unplugged {
+		return nil, errors.New("CKR_DEVICE_REMOVED")
+	}
+	return s.Signer.Sign(rand, digest, opts)
+}
+
+func TestTokenSignerErrors(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := &unpluggedSigner{Signer: key}
+
+	caCert := newTestCACertificate(t, "test-ca.dstcorp.io", key, nil, time.Now().Add(24*time.Hour))
+	c, err := newCA("test", []byte(caCert.pem()), &tokenSigner{Signer: token, token: "test-token"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	cert, _, err := c.CreateCertificate(ctx, "foo.dstcorp.io", []string{"foo.dstcorp.io"},
+		24*time.Hour, "", "", pkix.Name{}, nil)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %s", err)
+	}
+	if err = parseTestCertificate(t, cert).CheckSignatureFrom(&c.SigningCertificate); err != nil {
+		t.Errorf("the certificate was not signed by the token: %s", err)
+	}
+
+	token.unplugged = true
+	_, _, err = c.CreateCertificate(ctx, "foo.dstcorp.io", []string{"foo.dstcorp.io"},
+		24*time.Hour, "", "", pkix.Name{}, nil)
+	if status.Code(statusError(err)) != codes.Unavailable {
+		t.Errorf("expected Unavailable, got %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package backend
+
+import (
+	"crypto/x509/pkix"
+	"fmt"
+	"strings"
+
+	"github.com/mchudgins/certMgr/pkg/certMgr"
+)
+
+// the subject fields a requester may be permitted to supply
+const (
+	subjectOrganization       = "organization"
+	subjectOrganizationalUnit = "organizationalunit"
+	subjectCountry            = "country"
+	subjectLocality           = "locality"
+	subjectProvince           = "province"
+)
+
+// subjectPolicy determines the subject distinguished name of issued certificates
+type subjectPolicy struct {
+	Fixed           pkix.Name           // values placed in every certificate's subject
+	RequesterFields map[string]bool     // fields the requester may supply
+	AllowedValues   map[string][]string // permitted values of requester supplied fields
+}
+
+func newSubjectPolicy(cfg certMgr.SubjectConfig) (*subjectPolicy, error) {
+	p := &subjectPolicy{
+		RequesterFields: make(map[string]bool),
+		AllowedValues:   make(map[string][]string),
+	}
+
+	if len(cfg.Organization) > 0 {
+		p.Fixed.Organization = []string{cfg.Organization}
+	}
+	p.Fixed.OrganizationalUnit = cfg.OrganizationalUnit
+	if len(cfg.Country) > 0 {
+		p.Fixed.Country = []string{cfg.Country}
+	}
+	if len(cfg.Locality) > 0 {
+		p.Fixed.Locality = []string{cfg.Locality}
+	}
+	if len(cfg.Province) > 0 {
+		p.Fixed.Province = []string{cfg.Province}
+	}
+
+	for _, f := range cfg.RequesterFields {
+		field := strings.ToLower(f)
+		if !isSubjectField(field) {
+			return nil, fmt.Errorf("unknown subject field %s", f)
+		}
+		p.RequesterFields[field] = true
+	}
+
+	for f, values := range cfg.AllowedValues {
+		field := strings.ToLower(f)
+		if !isSubjectField(field) {
+			return nil, fmt.Errorf("unknown subject field %s", f)
+		}
+		p.AllowedValues[field] = values
+	}
+
+	return p, nil
+}
+
+func isSubjectField(field string) bool {
+	switch field {
+	case subjectOrganization, subjectOrganizationalUnit, subjectCountry, subjectLocality, subjectProvince:
+		return true
+	}
+	return false
+}
+
+// subject builds the certificate's subject from the fixed values of the
+// policy and the fields supplied by the requester. Requested values which
+// merely repeat the fixed values are accepted; anything else must be a
+// requester field and, when restricted, one of its allowed values.
+func (p *subjectPolicy) subject(commonName string, requested pkix.Name) (pkix.Name, error) {
+	subject := pkix.Name{
+		CommonName:         commonName,
+		Organization:       p.Fixed.Organization,
+		OrganizationalUnit: p.Fixed.OrganizationalUnit,
+		Country:            p.Fixed.Country,
+		Locality:           p.Fixed.Locality,
+		Province:           p.Fixed.Province,
+	}
+
+	var err error
+	if subject.Organization, err = p.merge(subjectOrganization, subject.Organization, requested.Organization, false); err != nil {
+		return subject, err
+	}
+	if subject.OrganizationalUnit, err = p.merge(subjectOrganizationalUnit, subject.OrganizationalUnit, requested.OrganizationalUnit, true); err != nil {
+		return subject, err
+	}
+	if subject.Country, err = p.merge(subjectCountry, subject.Country, requested.Country, false); err != nil {
+		return subject, err
+	}
+	if subject.Locality, err = p.merge(subjectLocality, subject.Locality, requested.Locality, false); err != nil {
+		return subject, err
+	}
+	if subject.Province, err = p.merge(subjectProvince, subject.Province, requested.Province, false); err != nil {
+		return subject, err
+	}
+
+	return subject, nil
+}
+
+// requesterFields returns the requested subject without the fields the requester
+// may not supply, which therefore take their fixed values. CSRs commonly carry
+// such fields (e.g. openssl's defaults), so they are dropped rather than refused.
+func (p *subjectPolicy) requesterFields(requested pkix.Name) pkix.Name {
+	fields := map[string]*[]string{
+		subjectOrganization:       &requested.Organization,
+		subjectOrganizationalUnit: &requested.OrganizationalUnit,
+		subjectCountry:            &requested.Country,
+		subjectLocality:           &requested.Locality,
+		subjectProvince:           &requested.Province,
+	}
+	for field, values := range fields {
+		if !p.RequesterFields[field] {
+			*values = nil
+		}
+	}
+	return requested
+}
+
+// merge combines the fixed and requested values of a subject field.
+// Multi-valued fields (OU) append the requested values to the fixed values;
+// single-valued fields have their fixed value replaced.
+func (p *subjectPolicy) merge(field string, fixed []string, requested []string, multiValued bool) ([]string, error) {
+	var added []string
+	for _, value := range requested {
+		value = strings.TrimSpace(value)
+		if len(value) == 0 || contains(fixed, value) {
+			continue
+		}
+
+		if !p.RequesterFields[field] {
+			return nil, policyErrorf("the subject's %s may not be specified by the requester", field)
+		}
+
+		if allowed, ok := p.AllowedValues[field]; ok && !contains(allowed, value) {
+			return nil, policyErrorf("%s is not a permitted value for the subject's %s", value, field)
+		}
+
+		added = append(added, value)
+	}
+
+	if len(added) == 0 {
+		return fixed, nil
+	}
+	if !multiValued {
+		if len(added) > 1 {
+			return nil, policyErrorf("the subject's %s may only have one value", field)
+		}
+		return added, nil
+	}
+
+	values := make([]string, 0, len(fixed)+len(added))
+	values = append(values, fixed...)
+	return append(values, added...), nil
+}
+
+// contains performs a case insensitive search of the list
+func contains(list []string, value string) bool {
+	for _, s := range list {
+		if strings.EqualFold(s, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package backend
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mchudgins/certMgr/pkg/certMgr"
+	"golang.org/x/net/context"
+)
+
+func newTestSubjectPolicy(t *testing.T) *subjectPolicy {
+	p, err := newSubjectPolicy(certMgr.SubjectConfig{
+		Organization:       "DST Systems, Inc",
+		OrganizationalUnit: []string{"Engineering"},
+		Country:            "US",
+		RequesterFields:    []string{"organizationalUnit", "Locality"},
+		AllowedValues:      map[string][]string{"locality": {"Kansas City", "Boston"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestNewSubjectPolicy(t *testing.T) {
+	p := newTestSubjectPolicy(t)
+	if !p.RequesterFields[subjectOrganizationalUnit] || !p.RequesterFields[subjectLocality] || p.RequesterFields[subjectCountry] {
+		t.Errorf("unexpected requester fields %v", p.RequesterFields)
+	}
+
+	for _, cfg := range []certMgr.SubjectConfig{
+		{RequesterFields: []string{"commonName"}},
+		{AllowedValues: map[string][]string{"street": {"Main"}}},
+	} {
+		if _, err := newSubjectPolicy(cfg); err == nil || !strings.Contains(err.Error(), "unknown subject field") {
+			t.Errorf("%+v: expected an unknown field, got %v", cfg, err)
+		}
+	}
+}
+
+func TestSubjectPolicy(t *testing.T) {
+	p := newTestSubjectPolicy(t)
+
+	tests := []struct {
+		name      string
+		requested pkix.Name
+		expected  pkix.Name
+		refusal   string // substring of the expected refusal, empty if permitted
+	}{
+		{"fixed values", pkix.Name{},
+			pkix.Name{Organization: []string{"DST Systems, Inc"}, OrganizationalUnit: []string{"Engineering"}, Country: []string{"US"}}, ""},
+		{"repeated fixed values", pkix.Name{Organization: []string{"dst systems, inc"}, Country: []string{"US"}},
+			pkix.Name{Organization: []string{"DST Systems, Inc"}, OrganizationalUnit: []string{"Engineering"}, Country: []string{"US"}}, ""},
+		{"requester fields", pkix.Name{OrganizationalUnit: []string{"SRE"}, Locality: []string{"Boston"}},
+			pkix.Name{Organization: []string{"DST Systems, Inc"}, OrganizationalUnit: []string{"Engineering", "SRE"},
+				Country: []string{"US"}, Locality: []string{"Boston"}}, ""},
+		{"fixed field", pkix.Name{Country: []string{"GB"}}, pkix.Name{}, "country may not be specified"},
+		{"disallowed value", pkix.Name{Locality: []string{"Denver"}}, pkix.Name{}, "Denver is not a permitted value"},
+		{"several values", pkix.Name{Locality: []string{"Boston", "Kansas City"}}, pkix.Name{}, "may only have one value"},
+	}
+
+	for _, test := range tests {
+		subject, err := p.subject("foo.dstcorp.io", test.requested)
+		if len(test.refusal) > 0 {
+			if _, ok := err.(*policyError); !ok || !strings.Contains(err.Error(), test.refusal) {
+				t.Errorf("%s: expected a policy error %q, got %v", test.name, test.refusal, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		test.expected.CommonName = "foo.dstcorp.io"
+		if !reflect.DeepEqual(subject, test.expected) {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, subject)
+		}
+	}
+
+	// only the requester fields of a CSR are considered
+	csrSubject := pkix.Name{
+		Organization:       []string{"Internet Widgits Pty Ltd"},
+		OrganizationalUnit: []string{"SRE"},
+		Country:            []string{"AU"},
+		Province:           []string{"Some-State"},
+		Locality:           []string{"Boston"},
+	}
+	expected := pkix.Name{OrganizationalUnit: []string{"SRE"}, Locality: []string{"Boston"}}
+	if fields := p.requesterFields(csrSubject); !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expected %s, got %s", expected, fields)
+	}
+}
+
+func TestSignCertificateRequestSubject(t *testing.T) {
+	c := newTestCA(t)
+	c.Subject = newTestSubjectPolicy(t)
+
+	key, err := generateKey(KeyTypeECDSAP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// openssl's default subject
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{
+		CommonName:   "foo.dstcorp.io",
+		Organization: []string{"Internet Widgits Pty Ltd"},
+		Country:      []string{"AU"},
+		Province:     []string{"Some-State"},
+	}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+
+	certPEM, err := c.SignCertificateRequest(context.Background(), csrPEM, day, "server", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := parseTestCertificate(t, certPEM)
+	if !reflect.DeepEqual(cert.Subject.Organization, []string{"DST Systems, Inc"}) ||
+		!reflect.DeepEqual(cert.Subject.Country, []string{"US"}) || len(cert.Subject.Province) != 0 {
+		t.Errorf("expected the fixed subject, got %s", cert.Subject)
+	}
+}
@@ -0,0 +1,97 @@
+package backend
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testCACertificate is a test CA's certificate & key
+type testCACertificate struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// newTestCACertificate creates a CA certificate for the key (a new ECDSA P-256 key
+// if nil), issued by the parent or, if nil, self-signed. The CA may only issue for
+// the domains, or for any name without them.
+func newTestCACertificate(t *testing.T, name string, key crypto.Signer, parent *testCACertificate,
+	notAfter time.Time, domains ...string) *testCACertificate {
+	return issueTestCACertificate(t, &x509.Certificate{
+		Subject:             pkix.Name{CommonName: name},
+		NotAfter:            notAfter,
+		PermittedDNSDomains: domains,
+	}, key, parent)
+}
+
+// issueTestCACertificate completes the template as a CA certificate & issues it as
+// newTestCACertificate does
+func issueTestCACertificate(t *testing.T, template *x509.Certificate, key crypto.Signer,
+	parent *testCACertificate) *testCACertificate {
+	var err error
+	if key == nil {
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	template.SerialNumber, err = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.NotBefore = time.Now().Add(-2 * time.Hour)
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	issuer, signer := template, key
+	if parent != nil {
+		issuer, signer = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, key.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCACertificate{cert: cert, key: key}
+}
+
+func (c *testCACertificate) pem() string {
+	return encodeChain([]*x509.Certificate{c.cert})
+}
+
+// keyPEM returns the PKCS#8 encoded key
+func (c *testCACertificate) keyPEM(t *testing.T) string {
+	der, err := x509.MarshalPKCS8PrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+// ca creates the named CA with the certificate, its key & the bundle
+func (c *testCACertificate) ca(t *testing.T, name string, bundle string) *ca {
+	ca, err := createCA(name, []byte(c.pem()), []byte(c.keyPEM(t)), bundle, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+// newTestCA creates a self-signed CA permitted to issue for dstcorp.io
+func newTestCA(t *testing.T) *ca {
+	c := newTestCACertificate(t, "test-ca.dstcorp.io", nil, nil, time.Now().Add(10*365*24*time.Hour), "dstcorp.io").
+		ca(t, "test", "")
+	c.Store = NewMemoryStore()
+	return c
+}
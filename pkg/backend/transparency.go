@@ -0,0 +1,432 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mchudgins/certMgr/pkg/certMgr"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// the URL path prefix of the RFC 6962 log API
+	ctPathPrefix = "/ct/v1/"
+
+	// the most entries returned by one get-entries request
+	maxCTEntries = 1000
+)
+
+// transparencyLog is a certificate transparency log of every certificate the CA
+// issues: an append-only Merkle tree with RFC 6962 hashing. Signed tree heads
+// are published periodically, so a certificate is provably included once a tree
+// head covering it has been published. The log is served with the RFC 6962 API.
+type transparencyLog struct {
+	sync.RWMutex
+	ca     *ca
+	file   *os.File
+	leaves [][]byte       // MerkleTreeLeaf structures, the leaf_input of get-entries
+	hashes [][]byte       // the leaves' hashes
+	index  map[string]int // leaf hash to the (first) index of the leaf
+	sth    *signedTreeHead
+}
+
+// ctEntry is the persisted form of a log entry
+type ctEntry struct {
+	Timestamp   uint64 `json:"timestamp"`
+	Certificate []byte `json:"certificate"`
+}
+
+// signedTreeHead is the get-sth response; the signature is the CA's signature of
+// the RFC 6962 TreeHeadSignature structure
+type signedTreeHead struct {
+	TreeSize  uint64 `json:"tree_size"`
+	Timestamp uint64 `json:"timestamp"`
+	RootHash  []byte `json:"sha256_root_hash"`
+	Signature []byte `json:"tree_head_signature"`
+}
+
+type ctConsistencyReply struct {
+	Consistency [][]byte `json:"consistency"`
+}
+
+type ctProofReply struct {
+	LeafIndex uint64   `json:"leaf_index"`
+	AuditPath [][]byte `json:"audit_path"`
+}
+
+type ctEntriesReply struct {
+	Entries []ctLeafEntry `json:"entries"`
+}
+
+type ctLeafEntry struct {
+	LeafInput []byte `json:"leaf_input"`
+	ExtraData []byte `json:"extra_data"`
+}
+
+// ctTimestamp returns the timestamp of the certificate's log entry: its notBefore,
+// in milliseconds, so the leaf can be computed from the certificate alone
+func ctTimestamp(cert *x509.Certificate) uint64 {
+	return uint64(cert.NotBefore.Unix()) * 1000
+}
+
+// merkleTreeLeaf returns the RFC 6962 MerkleTreeLeaf of an X.509 certificate entry
+func merkleTreeLeaf(timestamp uint64, der []byte) []byte {
+	var b bytes.Buffer
+	b.WriteByte(0) // version v1
+	b.WriteByte(0) // leaf_type timestamped_entry
+	binary.Write(&b, binary.BigEndian, timestamp)
+	binary.Write(&b, binary.BigEndian, uint16(0)) // entry_type x509_entry
+	b.Write([]byte{byte(len(der) >> 16), byte(len(der) >> 8), byte(len(der))})
+	b.Write(der)
+	binary.Write(&b, binary.BigEndian, uint16(0)) // no extensions
+	return b.Bytes()
+}
+
+// treeHeadSignatureInput returns the RFC 6962 TreeHeadSignature structure the CA signs
+func treeHeadSignatureInput(sth *signedTreeHead) []byte {
+	var b bytes.Buffer
+	b.WriteByte(0) // version v1
+	b.WriteByte(1) // signature_type tree_hash
+	binary.Write(&b, binary.BigEndian, sth.Timestamp)
+	binary.Write(&b, binary.BigEndian, sth.TreeSize)
+	b.Write(sth.RootHash)
+	return b.Bytes()
+}
+
+// newTransparencyLog opens the log, rebuilding the tree from its existing entries
+func newTransparencyLog(c *ca, filename string) (*transparencyLog, error) {
+	t := &transparencyLog{ca: c, index: make(map[string]int)}
+
+	if f, err := os.Open(filename); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1024*1024)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+
+			var e ctEntry
+			if err = json.Unmarshal(line, &e); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("entry %d of the transparency log %s is damaged", len(t.leaves), filename)
+			}
+			t.add(merkleTreeLeaf(e.Timestamp, e.Certificate))
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	t.file = f
+
+	log.WithFields(log.Fields{"file": filename, "entries": len(t.leaves)}).Info("transparency log opened")
+
+	return t, nil
+}
+
+// add appends the leaf to the tree. The caller must hold the lock.
+func (t *transparencyLog) add(leaf []byte) {
+	hash := leafHash(leaf)
+	if _, ok := t.index[string(hash)]; !ok {
+		t.index[string(hash)] = len(t.hashes)
+	}
+	t.leaves = append(t.leaves, leaf)
+	t.hashes = append(t.hashes, hash)
+}
+
+// append logs the DER encoded certificate
+func (t *transparencyLog) append(der []byte) error {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(ctEntry{Timestamp: ctTimestamp(cert), Certificate: der})
+	if err != nil {
+		return err
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	_, err = t.file.Write(append(line, '\n'))
+	if err == nil {
+		err = t.file.Sync()
+	}
+	if err != nil {
+		log.WithError(err).WithField("file", t.file.Name()).Error("Unable to append to the transparency log")
+		return err
+	}
+
+	t.add(merkleTreeLeaf(ctTimestamp(cert), der))
+
+	return nil
+}
+
+// publish signs a tree head for the current tree
+func (t *transparencyLog) publish() error {
+	t.Lock()
+	defer t.Unlock()
+
+	sth := &signedTreeHead{
+		TreeSize:  uint64(len(t.hashes)),
+		Timestamp: uint64(time.Now().UnixNano() / int64(time.Millisecond)),
+		RootHash:  merkleRoot(t.hashes),
+	}
+
+	signature, err := signData(t.ca.SigningKey, treeHeadSignatureInput(sth))
+	if err != nil {
+		log.WithError(err).WithField("ca", t.ca.Name).Error("Unable to sign the tree head")
+		return err
+	}
+	sth.Signature = signature
+
+	t.sth = sth
+	log.WithFields(log.Fields{"ca": t.ca.Name, "treeSize": sth.TreeSize}).Info("tree head published")
+
+	return nil
+}
+
+// run publishes tree heads on a schedule, starting with one unless a tree head
+// has already been published. Without an interval, only that tree head is
+// published.
+func (t *transparencyLog) run(interval time.Duration) {
+	t.RLock()
+	published := t.sth != nil
+	t.RUnlock()
+	if !published {
+		if err := t.publish(); err != nil {
+			log.WithError(err).WithField("ca", t.ca.Name).Error("Tree head publication failed")
+		}
+	}
+
+	if interval <= 0 {
+		log.WithField("ca", t.ca.Name).Warn("scheduled tree head publication is disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := t.publish(); err != nil {
+			log.WithError(err).WithField("ca", t.ca.Name).Error("Scheduled tree head publication failed")
+		}
+	}
+}
+
+func (t *transparencyLog) Close() error {
+	return t.file.Close()
+}
+
+// ServeHTTP serves get-sth, get-sth-consistency, get-proof-by-hash & get-entries
+// (RFC 6962, section 4) for the latest signed tree head
+func (t *transparencyLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.RLock()
+	defer t.RUnlock()
+
+	if t.sth == nil {
+		http.Error(w, "no tree head has been published", http.StatusServiceUnavailable)
+		return
+	}
+
+	var reply interface{}
+	var err error
+	switch strings.TrimPrefix(r.URL.Path, ctPathPrefix) {
+	case "get-sth":
+		reply = t.sth
+	case "get-sth-consistency":
+		reply, err = t.getConsistency(r.URL.Query())
+	case "get-proof-by-hash":
+		reply, err = t.getProof(r.URL.Query())
+	case "get-entries":
+		reply, err = t.getEntries(r.URL.Query())
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	switch err {
+	case nil:
+	case errCTNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(reply); err != nil {
+		log.WithError(err).Warn("Unable to write the transparency log response")
+	}
+}
+
+var errCTNotFound = errors.New("the hash is not in the tree")
+
+// treeSizeParam returns the named tree size, which must be within the published tree
+func (t *transparencyLog) treeSizeParam(params url.Values, name string) (uint64, error) {
+	size, err := strconv.ParseUint(params.Get(name), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a tree size", name)
+	}
+	if size > t.sth.TreeSize {
+		return 0, fmt.Errorf("%s is beyond the published tree size, %d", name, t.sth.TreeSize)
+	}
+	return size, nil
+}
+
+func (t *transparencyLog) getConsistency(params url.Values) (*ctConsistencyReply, error) {
+	first, err := t.treeSizeParam(params, "first")
+	if err != nil {
+		return nil, err
+	}
+	second, err := t.treeSizeParam(params, "second")
+	if err != nil {
+		return nil, err
+	}
+	if first > second {
+		return nil, errors.New("first must not exceed second")
+	}
+
+	return &ctConsistencyReply{Consistency: consistencyProof(t.hashes[:second], int(first))}, nil
+}
+
+func (t *transparencyLog) getProof(params url.Values) (*ctProofReply, error) {
+	hash, err := base64.StdEncoding.DecodeString(params.Get("hash"))
+	if err != nil || len(hash) == 0 {
+		return nil, errors.New("hash must be a base64 encoded leaf hash")
+	}
+	size, err := t.treeSizeParam(params, "tree_size")
+	if err != nil {
+		return nil, err
+	}
+
+	i, ok := t.index[string(hash)]
+	if !ok || uint64(i) >= size {
+		return nil, errCTNotFound
+	}
+
+	return &ctProofReply{LeafIndex: uint64(i), AuditPath: inclusionProof(t.hashes[:size], i)}, nil
+}
+
+func (t *transparencyLog) getEntries(params url.Values) (*ctEntriesReply, error) {
+	start, err := strconv.ParseUint(params.Get("start"), 10, 64)
+	if err != nil {
+		return nil, errors.New("start must be an entry index")
+	}
+	end, err := strconv.ParseUint(params.Get("end"), 10, 64)
+	if err != nil || end < start {
+		return nil, errors.New("end must be an entry index, no less than start")
+	}
+	if start >= t.sth.TreeSize {
+		return nil, fmt.Errorf("start is beyond the published tree size, %d", t.sth.TreeSize)
+	}
+	if end >= t.sth.TreeSize {
+		end = t.sth.TreeSize - 1
+	}
+	if end-start >= maxCTEntries {
+		end = start + maxCTEntries - 1
+	}
+
+	reply := &ctEntriesReply{}
+	for _, leaf := range t.leaves[start : end+1] {
+		reply.Entries = append(reply.Entries, ctLeafEntry{LeafInput: leaf, ExtraData: []byte{}})
+	}
+	return reply, nil
+}
+
+// VerifyCTInclusion checks that the certificate is included in a tree head of the
+// transparency log at logURL signed by the signing CA
+func VerifyCTInclusion(cfg *certMgr.AppConfig, logURL string, certFilename string) error {
+	caCert, err := signingCACertificate(cfg)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(certFilename)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return fmt.Errorf("%s does not contain a PEM encoded certificate", certFilename)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return err
+	}
+
+	logURL = strings.TrimSuffix(logURL, "/") + "/"
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	var sth signedTreeHead
+	if err = getCTReply(client, logURL+"get-sth", &sth); err != nil {
+		return err
+	}
+	if err = checkDataSignature(caCert, treeHeadSignatureInput(&sth), sth.Signature); err != nil {
+		return fmt.Errorf("the tree head is not signed by %s: %s", caCert.Subject.CommonName, err)
+	}
+
+	hash := leafHash(merkleTreeLeaf(ctTimestamp(cert), block.Bytes))
+	params := url.Values{}
+	params.Set("hash", base64.StdEncoding.EncodeToString(hash))
+	params.Set("tree_size", strconv.FormatUint(sth.TreeSize, 10))
+
+	var proof ctProofReply
+	if err = getCTReply(client, logURL+"get-proof-by-hash?"+params.Encode(), &proof); err != nil {
+		return fmt.Errorf("certificate %s is not included in the tree of %d entries: %s",
+			SerialString(cert.SerialNumber), sth.TreeSize, err)
+	}
+	if err = verifyInclusion(hash, proof.LeafIndex, sth.TreeSize, proof.AuditPath, sth.RootHash); err != nil {
+		return fmt.Errorf("the inclusion proof of certificate %s: %s", SerialString(cert.SerialNumber), err)
+	}
+
+	log.WithFields(log.Fields{
+		"serial":    SerialString(cert.SerialNumber),
+		"leafIndex": proof.LeafIndex,
+		"treeSize":  sth.TreeSize,
+		"treeHead":  time.Unix(0, int64(sth.Timestamp)*int64(time.Millisecond)).UTC(),
+	}).Info("certificate is included in the transparency log")
+
+	return nil
+}
+
+func getCTReply(client *http.Client, u string, reply interface{}) error {
+	resp, err := client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
@@ -0,0 +1,198 @@
+package backend
+
+import (
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mchudgins/certMgr/pkg/certMgr"
+	"golang.org/x/net/context"
+)
+
+func TestTransparencyLog(t *testing.T) {
+	ctx := context.Background()
+	c := newTestCA(t)
+
+	dir, err := ioutil.TempDir("", "certMgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "ct.log")
+
+	c.CT, err = newTransparencyLog(c, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(ctPathPrefix, c.CT)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	logURL := server.URL + ctPathPrefix
+
+	cfg := *certMgr.DefaultAppConfig
+	cfg.Backend.SigningCACertificate = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.SigningCertificate.Raw}))
+
+	issue := func(name string) string {
+		cert, _, err := c.CreateCertificate(ctx, name, nil, 24*time.Hour, "", "", pkix.Name{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certFilename := filepath.Join(dir, name+".pem")
+		if err = ioutil.WriteFile(certFilename, []byte(cert), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return certFilename
+	}
+
+	get := func(path string, reply interface{}) int {
+		resp, err := http.Get(logURL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			if err = json.NewDecoder(resp.Body).Decode(reply); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	if code := get("get-sth", &signedTreeHead{}); code != http.StatusServiceUnavailable {
+		t.Errorf("expected no tree head before publication, got %d", code)
+	}
+
+	var certs []string
+	for i := 0; i < 5; i++ {
+		certs = append(certs, issue(fmt.Sprintf("web%d.dstcorp.io", i)))
+	}
+	if err = c.CT.publish(); err != nil {
+		t.Fatal(err)
+	}
+	var first signedTreeHead
+	get("get-sth", &first)
+
+	for i, cert := range certs {
+		if err = VerifyCTInclusion(&cfg, logURL, cert); err != nil {
+			t.Errorf("certificate %d: %s", i, err)
+		}
+	}
+
+	// a certificate is only included once a tree head covering it is published
+	late := issue("late.dstcorp.io")
+	if err = VerifyCTInclusion(&cfg, logURL, late); err == nil || !strings.Contains(err.Error(), "not included") {
+		t.Errorf("expected the certificate not to be included yet, got %v", err)
+	}
+	if err = c.CT.publish(); err != nil {
+		t.Fatal(err)
+	}
+	if err = VerifyCTInclusion(&cfg, logURL, late); err != nil {
+		t.Errorf("expected the certificate to be included: %s", err)
+	}
+
+	// the tree heads are consistent
+	var second signedTreeHead
+	get("get-sth", &second)
+	var consistency ctConsistencyReply
+	if code := get(fmt.Sprintf("get-sth-consistency?first=%d&second=%d", first.TreeSize, second.TreeSize), &consistency); code != http.StatusOK {
+		t.Fatalf("get-sth-consistency: %d", code)
+	}
+	if err = verifyConsistency(first.TreeSize, second.TreeSize, first.RootHash, second.RootHash, consistency.Consistency); err != nil {
+		t.Errorf("tree heads are inconsistent: %s", err)
+	}
+
+	var entries ctEntriesReply
+	if code := get("get-entries?start=4&end=9", &entries); code != http.StatusOK || len(entries.Entries) != 2 {
+		t.Errorf("expected entries 4 & 5, got %d entries (%d)", len(entries.Entries), code)
+	}
+	if code := get("get-sth-consistency?first=1&second=7", &consistency); code != http.StatusBadRequest {
+		t.Errorf("expected a tree size beyond the tree head to be refused, got %d", code)
+	}
+
+	// a tree head signed by another CA is refused
+	other := *certMgr.DefaultAppConfig
+	otherCA := newTestCA(t)
+	other.Backend.SigningCACertificate = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: otherCA.SigningCertificate.Raw}))
+	if err = VerifyCTInclusion(&other, logURL, certs[0]); err == nil || !strings.Contains(err.Error(), "not signed by") {
+		t.Errorf("expected the tree head signature to be refused, got %v", err)
+	}
+
+	// reopening the log rebuilds the same tree
+	c.CT.Close()
+	reopened, err := newTransparencyLog(c, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if root := merkleRoot(reopened.hashes); string(root) != string(second.RootHash) {
+		t.Error("the reopened log has a different root")
+	}
+}
+
+func TestTransparencyLogRun(t *testing.T) {
+	c := newTestCA(t)
+
+	dir, err := ioutil.TempDir("", "certMgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c.CT, err = newTransparencyLog(c, filepath.Join(dir, "ct.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.CT.Close()
+
+	// a tree head is published at once, even without an interval
+	c.CT.run(0)
+	first := c.CT.sth
+	if first == nil || first.TreeSize != 0 {
+		t.Fatalf("expected an empty tree head, got %+v", first)
+	}
+
+	// an already published tree head is kept
+	c.CT.run(-time.Minute)
+	if c.CT.sth != first {
+		t.Error("expected the published tree head to be kept")
+	}
+}
+
+func TestTransparencyLogFailure(t *testing.T) {
+	c := newTestCA(t)
+
+	dir, err := ioutil.TempDir("", "certMgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c.CT, err = newTransparencyLog(c, filepath.Join(dir, "ct.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a certificate the log cannot record is neither issued nor inventoried
+	c.CT.Close()
+	if _, _, err = c.CreateCertificate(context.Background(), "foo.dstcorp.io", nil, day, "", "", pkix.Name{}, nil); err == nil {
+		t.Fatal("expected the certificate to be refused")
+	}
+	recs, _, err := c.Store.List(context.Background(), &CertificateFilter{}, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 0 {
+		t.Errorf("expected an empty inventory, got %d certificates", len(recs))
+	}
+}
@@ -0,0 +1,70 @@
+package backend
+
+import (
+	"crypto/x509"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// wildcardBase returns the domain whose immediate subdomains a wildcard
+// name covers, e.g. foo.dstcorp.io for *.foo.dstcorp.io
+func wildcardBase(name string) (string, bool) {
+	if strings.HasPrefix(name, "*.") {
+		return name[2:], true
+	}
+	return "", false
+}
+
+// checkWildcardForm refuses wildcards other than a leftmost label of a DNS
+// name, and wildcards directly under a public suffix (*.com, *.co.uk)
+func checkWildcardForm(name string, domain string, kind string) error {
+	if !strings.Contains(domain, "*") {
+		return nil
+	}
+	if kind != sanDNS {
+		return policyErrorf("%s: refused, wildcards are only permitted in DNS names", name)
+	}
+
+	base, ok := wildcardBase(domain)
+	if !ok || strings.Contains(base, "*") {
+		return policyErrorf("%s: refused, only the leftmost label may be a wildcard", name)
+	}
+
+	if suffix, _ := publicsuffix.PublicSuffix(base); suffix == base {
+		return policyErrorf("%s: refused, wildcards directly under the public suffix %s are not permitted", name, base)
+	}
+
+	return nil
+}
+
+// checkApexWildcard refuses a wildcard directly under one of the certificate's
+// permitted DNS domains, e.g. *.dstcorp.io when dstcorp.io is permitted
+func checkApexWildcard(cert *x509.Certificate, name string) error {
+	base, ok := wildcardBase(name)
+	if !ok {
+		return nil
+	}
+
+	for _, constraint := range cert.PermittedDNSDomains {
+		if strings.TrimPrefix(strings.ToLower(constraint), ".") == base {
+			return policyErrorf("%s: refused, wildcards directly under %s, a domain permitted by %s, are not enabled",
+				name, base, cert.Subject.CommonName)
+		}
+	}
+	return nil
+}
+
+// wildcardCovers returns true if a name the wildcard covers lies within the
+// constraint, although the wildcard name itself does not. For example,
+// *.dstcorp.io covers the constraint secret.dstcorp.io, but not .secret.dstcorp.io
+// (whose names are two labels deep) nor a.secret.dstcorp.io.
+func wildcardCovers(name string, constraint string) bool {
+	base, ok := wildcardBase(name)
+	if !ok || strings.HasPrefix(constraint, ".") {
+		return false
+	}
+
+	i := strings.Index(constraint, ".")
+	return i > 0 && constraint[i+1:] == base
+}
@@ -0,0 +1,144 @@
+package backend
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mchudgins/certMgr/pkg/certMgr"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestWildcardForm(t *testing.T) {
+	tests := []struct {
+		name    string
+		refusal string // substring of the expected refusal, empty if permitted
+	}{
+		{"*.foo.dstcorp.io", ""},
+		{"foo.dstcorp.io", ""},
+		{"foo.*.dstcorp.io", "only the leftmost label"},
+		{"f*.dstcorp.io", "only the leftmost label"},
+		{"*.*.dstcorp.io", "only the leftmost label"},
+		{"*", "only the leftmost label"},
+		{"*.com", "public suffix com"},
+		{"*.co.uk", "public suffix co.uk"},
+		{"*.dstcorp.co.uk", ""},
+		{"bob@*.dstcorp.io", "only permitted in DNS names"},
+		{"spiffe://*.dstcorp.io/db", "only permitted in DNS names"},
+	}
+
+	for _, test := range tests {
+		_, err := normalizeName(test.name, false)
+		switch {
+		case len(test.refusal) == 0 && err != nil:
+			t.Errorf("%s: unexpected refusal: %s", test.name, err)
+		case len(test.refusal) > 0 && err == nil:
+			t.Errorf("%s: expected a refusal", test.name)
+		case err != nil && !strings.Contains(err.Error(), test.refusal):
+			t.Errorf("%s: expected %q, got %q", test.name, test.refusal, err)
+		}
+	}
+}
+
+// TestWildcardNameConstraints checks wildcards against the constraints that
+// matchNameConstraint alone would let through
+func TestWildcardNameConstraints(t *testing.T) {
+	tests := []struct {
+		name       string
+		constraint string
+		matches    bool // matchNameConstraint(name, constraint)
+		covers     bool // wildcardCovers(name, constraint)
+	}{
+		// the wildcard covers secret.dstcorp.io, though its name is not within it
+		{"*.dstcorp.io", "secret.dstcorp.io", false, true},
+		{"*.dstcorp.io", ".secret.dstcorp.io", false, false},
+		{"*.dstcorp.io", "a.secret.dstcorp.io", false, false},
+		{"*.secret.dstcorp.io", "secret.dstcorp.io", true, false},
+		{"*.secret.dstcorp.io", ".secret.dstcorp.io", true, false},
+		{"*.dstcorp.io", "dstcorp.io", true, false},
+		{"*.dstcorp.io", "example.com", false, false},
+		{"foo.dstcorp.io", "bar.dstcorp.io", false, false},
+	}
+
+	for _, test := range tests {
+		if got := matchNameConstraint(test.name, test.constraint); got != test.matches {
+			t.Errorf("matchNameConstraint(%s, %s) = %t", test.name, test.constraint, got)
+		}
+		if got := wildcardCovers(test.name, test.constraint); got != test.covers {
+			t.Errorf("wildcardCovers(%s, %s) = %t", test.name, test.constraint, got)
+		}
+	}
+
+	c := newConstrainedCA(t)
+	c.Policy = &domainPolicy{rules: []*policyRule{{users: []string{"*"}, domains: []string{"dstcorp.io"}, wildcards: true}}}
+	c.ApexWildcards = true
+	ctx := metadata.NewContext(context.Background(), metadata.Pairs(remoteUserMetadataKey, "alice@dstcorp.com"))
+
+	for name, refusal := range map[string]string{
+		"*.foo.dstcorp.io":    "",
+		"*.dstcorp.io":        "excluded by test-ca.dstcorp.io",
+		"*.secret.dstcorp.io": "excluded by test-ca.dstcorp.io",
+		"*.legacy.dstcorp.io": "excluded by intermediate.dstcorp.io",
+		"*.example.com":       "not a domain permitted",
+	} {
+		err := c.validateNames(ctx, []string{"subject.dstcorp.io", name}, "server")[1].err
+		switch {
+		case len(refusal) == 0 && err != nil:
+			t.Errorf("%s: unexpected refusal: %s", name, err)
+		case len(refusal) > 0 && (err == nil || !strings.Contains(err.Error(), refusal)):
+			t.Errorf("%s: expected %q, got %v", name, refusal, err)
+		}
+	}
+}
+
+func TestWildcardPolicy(t *testing.T) {
+	c := newTestCA(t)
+	ctx := metadata.NewContext(context.Background(), metadata.Pairs(remoteUserMetadataKey, "alice@dstcorp.com"))
+
+	wildcardRefusal := func(name string) string {
+		err := c.validateNames(ctx, []string{"subject.dstcorp.io", name}, "server")[1].err
+		if err == nil {
+			return ""
+		}
+		return err.Error()
+	}
+
+	// without a policy no one is granted wildcards
+	if refusal := wildcardRefusal("*.foo.dstcorp.io"); !strings.Contains(refusal, "require a policy") {
+		t.Errorf("expected the wildcard to be refused without a policy, got %q", refusal)
+	}
+
+	p, err := newDomainPolicy(certMgr.PolicyConfig{Rules: []certMgr.PolicyRuleConfig{
+		{Users: []string{"alice@dstcorp.com"}, Domains: []string{"dstcorp.io"}},
+		{Users: []string{"alice@dstcorp.com"}, Domains: []string{"web.dstcorp.io"}, Wildcards: true},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Policy = p
+
+	if refusal := wildcardRefusal("*.web.dstcorp.io"); len(refusal) > 0 {
+		t.Errorf("expected *.web.dstcorp.io to be permitted: %s", refusal)
+	}
+	if refusal := wildcardRefusal("*.api.dstcorp.io"); !strings.Contains(refusal, "may not request wildcards") {
+		t.Errorf("expected *.api.dstcorp.io to be refused, got %q", refusal)
+	}
+	if refusal := wildcardRefusal("api.dstcorp.io"); len(refusal) > 0 {
+		t.Errorf("expected api.dstcorp.io to be permitted: %s", refusal)
+	}
+
+	// a wildcard directly under the CA's permitted domain needs ApexWildcards
+	p.rules[1].domains = []string{"dstcorp.io"}
+	if refusal := wildcardRefusal("*.dstcorp.io"); !strings.Contains(refusal, "are not enabled") {
+		t.Errorf("expected *.dstcorp.io to be refused, got %q", refusal)
+	}
+	c.ApexWildcards = true
+	if refusal := wildcardRefusal("*.dstcorp.io"); len(refusal) > 0 {
+		t.Errorf("expected *.dstcorp.io to be permitted: %s", refusal)
+	}
+
+	reply := c.checkEntitlements(ctx, []string{"*.web.dstcorp.io"}, "server")
+	if !reply.Permitted || len(reply.Entitlements) != 2 || !reply.Entitlements[1].Wildcards {
+		t.Errorf("unexpected dry-run reply: %+v", reply)
+	}
+}
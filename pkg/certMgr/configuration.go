@@ -14,14 +14,150 @@ type AppConfig struct {
 
 	// specific config options for each command & subcommand
 	Backend BackendConfig
+	Signer  SignerConfig
 }
 
 type BackendConfig struct {
-	AuthorizedCreators   []string // users authorized to create new certificates (an empty list permits anyone)
+	AuthorizedCreators   []string // users (or group:name) authorized to create new certificates; wildcards permitted, an empty list permits anyone
 	Bundle               string   // the pem-encoded bundle of intermediate CA's
+	RootCACertificate    string   // the pem-encoded root CA, completing a bundle without one (defaults to the embedded root)
 	SigningCACertificate string   // the pem-encoded signing CA
 	SigningCAKeyFilename string   // filename for the CA key
+	CAKeyPassphraseEnv   string   // environment variable holding the CA key's passphrase
+	CAKeyPassphraseFile  string   // file holding the CA key's passphrase (e.g. a mounted secret)
+	PKCS11               PKCS11Config
+	RemoteSigner         RemoteSignerConfig
 	MaxDuration          int      // maximum # of days this CA will issue a cert
+	ClampDuration        bool     // shorten longer requests to the maximum, rather than refusing them
+	AllowedKeyTypes      []string // key types this CA will generate (an empty list permits all)
+	Profiles             map[string]ProfileConfig
+	AllowedProfiles      []string // profiles requesters may select (an empty list permits the DefaultAllowedProfiles)
+	DefaultProfile       string   // profile used when the request does not name one
+	Subject              SubjectConfig
+	StoreType            string   // the certificate inventory's store: bolt or memory
+	StoreFilename        string   // filename of the bolt certificate store
+	CRLNumberFilename    string   // persists the number of the next CRL
+	CRLRefreshInterval   int      // # of hours between scheduled CRL generation (0 regenerates only on revocation)
+	CRLValidity          int      // # of hours until a CRL's nextUpdate
+	OCSPListenAddress    string   // address of the OCSP responder (empty disables it)
+	OCSPValidity         int      // # of hours until an OCSP response's nextUpdate
+	IssuerURL            string   // URL of the signing CA's certificate (aia_url)
+	OCSPURL              string   // URL of the OCSP responder (ocsp_url)
+	CRLURLs              []string // URLs of the CRL (crl_url)
+	PolicyFilename       string   // YAML or JSON file of per-user & per-group entitlements (empty permits everyone)
+	ApexWildcards        bool     // permit wildcards directly under the CA's permitted domains (*.dstcorp.io)
+	AuditFilename        string   // tamper-evident record of every CA operation (empty disables it)
+	AuditInterval        int      // # of audit log entries between checkpoints signed by the CA
+	CTLogFilename        string   // transparency log of every issued certificate (empty disables it)
+	CTPublishInterval    int      // # of minutes between signed tree heads of the transparency log (0 publishes only at startup)
+
+	// CAs are the named CAs hosted by the backend; when empty, the settings above
+	// describe its single CA. The first is the primary, whose key signs the audit
+	// & transparency logs.
+	CAs []CAConfig
+}
+
+// CAConfig declares one of the CAs hosted by the backend, with its own key, chain,
+// policy, profiles & subject. Settings left empty (or unset, for the pointers) are
+// inherited from the BackendConfig, except the key: a CA which names a key file,
+// PKCS#11 token or remote signer uses only that.
+type CAConfig struct {
+	Name                 string // selects the CA in requests and names its CRL (/crl/<name>.crl)
+	SigningCACertificate string // the pem-encoded signing CA
+	Bundle               string // the pem-encoded bundle of intermediate CA's
+	RootCACertificate    string // the pem-encoded root CA, completing a bundle without one
+	SigningCAKeyFilename string // filename for the CA key
+	CAKeyPassphraseEnv   string // environment variable holding the CA key's passphrase
+	CAKeyPassphraseFile  string // file holding the CA key's passphrase
+	PKCS11               PKCS11Config
+	RemoteSigner         RemoteSignerConfig
+	MaxDuration          *int     // maximum # of days this CA will issue a cert (0 is unlimited)
+	ClampDuration        *bool    // shorten longer requests to the maximum, rather than refusing them
+	AllowedKeyTypes      []string // key types this CA will generate
+	Profiles             map[string]ProfileConfig
+	AllowedProfiles      []string // profiles requesters may select
+	DefaultProfile       string   // profile used when the request does not name one
+	Subject              *SubjectConfig
+	PolicyFilename       string   // per-user & per-group entitlements for this CA
+	ApexWildcards        *bool    // permit wildcards directly under the CA's permitted domains
+	IssuerURL            string   // URL of the signing CA's certificate (aia_url)
+	OCSPURL              string   // URL of the OCSP responder (ocsp_url)
+	CRLURLs              []string // URLs of the CRL (crl_url)
+	CRLNumberFilename    string   // persists the number of the CA's next CRL (defaults to the backend's, suffixed with the name)
+}
+
+// PKCS11Config selects a CA key held in a PKCS#11 token, such as an HSM or SoftHSM2,
+// in place of the key file. The token is used when Module is set.
+type PKCS11Config struct {
+	Module     string // path of the token's PKCS#11 module (shared library)
+	TokenLabel string // label of the token holding the CA key
+	KeyLabel   string // label of the CA's private key
+	PinEnv     string // environment variable holding the token's user PIN
+	PinFile    string // file holding the token's user PIN
+}
+
+// RemoteSignerConfig selects a CA key held by a remote signer (`certMgr signer`),
+// in place of the key file. The signer is used when Address is set.
+type RemoteSignerConfig struct {
+	Address      string // address of the signer's gRPC service
+	ServerName   string // name expected in the signer's certificate (defaults to the address' host)
+	CAFilename   string // CA bundle verifying the signer's certificate
+	CertFilename string // the backend's client certificate
+	KeyFilename  string // the backend's client key
+	Timeout      int    // # of seconds to wait for a signature
+}
+
+// SignerConfig configures the remote signer, which holds the CA key on behalf of
+// the backend. Its key is configured as the backend's would be, with
+// Backend.SigningCAKeyFilename or Backend.PKCS11, and its TLS certificate with
+// CertFilename & KeyFilename.
+type SignerConfig struct {
+	ListenAddress     string   // address of the signer's gRPC service
+	ClientCAFilename  string   // CA bundle verifying the backends' client certificates
+	AuthorizedClients []string // names in the client certificates permitted to sign; wildcards permitted, an empty list permits any verified client
+	AuditFilename     string   // append-only record of every signature
+}
+
+// PolicyConfig is the content of the policy file
+type PolicyConfig struct {
+	Rules []PolicyRuleConfig
+}
+
+// PolicyRuleConfig entitles the matching users & groups to request names within
+// the domains & IP ranges using the profiles. Users and groups may be wildcard
+// patterns such as *@dstcorp.com.
+type PolicyRuleConfig struct {
+	Users    []string
+	Groups   []string
+	Domains  []string // name constraint style: dstcorp.io matches it & its subdomains, .dstcorp.io only the subdomains
+	IPRanges []string // CIDR notation
+	Profiles []string // an empty list permits every profile
+
+	Wildcards bool // the rule's users may request wildcards (*.foo.dstcorp.io) within its domains
+}
+
+// SubjectConfig describes the subject distinguished name of issued certificates.
+// The Organization, OrganizationalUnit, Country, Locality and Province values
+// are placed in every certificate's subject.
+type SubjectConfig struct {
+	Organization       string
+	OrganizationalUnit []string
+	Country            string
+	Locality           string
+	Province           string
+	RequesterFields    []string            // fields the requester may supply: organization, organizationalUnit, country, locality, province
+	AllowedValues      map[string][]string // permitted values for a requester supplied field (no entry permits any value)
+}
+
+// ProfileConfig describes the kind of certificate issued for a named profile
+type ProfileConfig struct {
+	KeyUsage        []string // digitalSignature, keyEncipherment, keyAgreement, ...
+	ExtKeyUsage     []string // serverAuth, clientAuth, codeSigning, emailProtection, ocspSigning
+	IsCA            bool     // value of the basic constraints CA flag
+	MaxPathLen      int      // basic constraints path length (only meaningful for a CA)
+	MustStaple      bool     // include the TLS feature (OCSP must-staple) extension
+	MaxDuration     int      // maximum # of days for this profile (0 defers to the CA's maximum)
+	AllowedSANTypes []string // dns, ip, email, uri
 }
 
 // the default configuration
@@ -36,12 +172,84 @@ var (
 		Insecure:           false,
 		Verbose:            false,
 		Backend:            defaultBackendConfig,
+		Signer: SignerConfig{
+			ListenAddress: ":50052",
+			AuditFilename: "signer-audit.log",
+		},
 	}
 
 	// defaultConfig holds default values
 	defaultBackendConfig = BackendConfig{
 		AuthorizedCreators:   []string{""},
 		SigningCAKeyFilename: "ca-key.pem",
+		CAKeyPassphraseEnv:   "CERTMGR_CA_PASSPHRASE",
 		MaxDuration:          365, // max duration, in days, for any certificate
+		Profiles:             DefaultProfiles,
+		DefaultProfile:       "mtls",
+		Subject: SubjectConfig{
+			Organization: "DST Systems, Inc",
+		},
+		PKCS11: PKCS11Config{
+			PinEnv: "CERTMGR_PKCS11_PIN",
+		},
+		RemoteSigner: RemoteSignerConfig{
+			Timeout: 10,
+		},
+		StoreType:     "bolt",
+		StoreFilename: "certMgr.db",
+
+		CRLNumberFilename:  "crlnumber",
+		CRLRefreshInterval: 12,
+		CRLValidity:        48,
+
+		OCSPListenAddress: ":9080",
+		OCSPValidity:      4,
+
+		AuditFilename: "audit.log",
+		AuditInterval: 100,
+
+		CTLogFilename:     "ct.log",
+		CTPublishInterval: 10,
+	}
+
+	// DefaultAllowedProfiles are the profiles requesters may select when a CA does not
+	// list them. Code signing must be permitted explicitly, and the OCSP signing profile
+	// is reserved for the CA's own responder.
+	DefaultAllowedProfiles = []string{"server", "client", "mtls", "email"}
+
+	// DefaultProfiles are the profiles available when none are configured
+	DefaultProfiles = map[string]ProfileConfig{
+		"server": {
+			KeyUsage:        []string{"digitalSignature", "keyEncipherment"},
+			ExtKeyUsage:     []string{"serverAuth"},
+			AllowedSANTypes: []string{"dns", "ip"},
+		},
+		"client": {
+			KeyUsage:        []string{"digitalSignature"},
+			ExtKeyUsage:     []string{"clientAuth"},
+			AllowedSANTypes: []string{"dns", "email", "uri"},
+		},
+		"mtls": {
+			KeyUsage:        []string{"digitalSignature", "keyEncipherment"},
+			ExtKeyUsage:     []string{"serverAuth", "clientAuth"},
+			AllowedSANTypes: []string{"dns", "ip"},
+		},
+		"code-signing": {
+			KeyUsage:        []string{"digitalSignature"},
+			ExtKeyUsage:     []string{"codeSigning"},
+			MaxDuration:     365,
+			AllowedSANTypes: []string{"dns"},
+		},
+		"email": {
+			KeyUsage:        []string{"digitalSignature", "keyEncipherment"},
+			ExtKeyUsage:     []string{"emailProtection"},
+			AllowedSANTypes: []string{"email"},
+		},
+		"ocsp-signing": {
+			KeyUsage:        []string{"digitalSignature"},
+			ExtKeyUsage:     []string{"ocspSigning"},
+			MaxDuration:     30,
+			AllowedSANTypes: []string{"dns"},
+		},
 	}
 )
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"regexp"
+	"strings"
 	"time"
 
 	"google.golang.org/grpc"
@@ -17,6 +18,7 @@ import (
 
 const (
 	remoteUserHeader         = "X-RemoteUser"
+	remoteGroupsHeader       = "X-RemoteGroups"
 	grpcMetadataHeaderPrefix = "Grpc-Metadata-"
 )
 
@@ -154,6 +156,12 @@ func (s *securityProxy) Handler(h http.Handler) http.Handler {
 		// resp.UserID needs to be passed along to the backend
 		r.Header.Set(grpcMetadataHeaderPrefix+remoteUserHeader, resp.UserID)
 
+		// as do the user's groups (never those supplied by the client)
+		r.Header.Del(grpcMetadataHeaderPrefix + remoteGroupsHeader)
+		if len(resp.Groups) > 0 {
+			r.Header.Set(grpcMetadataHeaderPrefix+remoteGroupsHeader, strings.Join(resp.Groups, ","))
+		}
+
 		// finally, pass the request along the processing chain
 		h.ServeHTTP(w, r)
 	})
@@ -13,7 +13,7 @@ var app = angular.module( 'app', [ 'ngRoute' ] )
               }]);
 
 app.controller('ListControl', function( $scope, $http ) {
-  $http.get( apiEndpoint ).success(function(data){
+  $http.get( apiEndpoint + "/certificates" ).success(function(data){
     $scope.list = data;
   })
   $scope.x = apiEndpoint;
@@ -40,8 +40,8 @@ app.controller('NewCertForm', function( $scope, $http ) {
 
   $http.get( apiEndpoint + "/permittedDomains" )
     .success( function( data ){
-      $scope.subzones = data.domains;
-      $scope.form = { subzone : "." + data.domains[ 0 ] };
+      $scope.subzones = data.permittedDomains || [];
+      $scope.form = { subzone : $scope.subzones.length ? "." + $scope.subzones[ 0 ] : "" };
       $scope.server = "";
     })
 });